@@ -1,7 +1,11 @@
 package cobalt
 
 import (
+	"io/ioutil"
+	"log"
+	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 )
 
@@ -74,3 +78,81 @@ func BenchmarkContextRequest(b *testing.B) {
 	}
 	b.ReportAllocs()
 }
+
+// discardResponseWriter is a http.ResponseWriter which doesn't allocate, unlike httptest.ResponseRecorder.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(int) {}
+
+func benchmarkRoute(b *testing.B, route, path string) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	c := New(&JSONEncoder{})
+	c.Get(route, func(ctx *Context) {
+		ctx.ServeStatus(http.StatusOK)
+	})
+
+	r := newRequest("GET", path, nil)
+	w := &discardResponseWriter{header: http.Header{}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		c.ServeHTTP(w, r)
+	}
+}
+
+func BenchmarkStaticRoute(b *testing.B) {
+	benchmarkRoute(b, "/users/new", "/users/new")
+}
+
+func BenchmarkParamRoute(b *testing.B) {
+	benchmarkRoute(b, "/users/:id/posts/:post", "/users/42/posts/7")
+}
+
+// serveAllocs is the most allocations cobalt may make per request on top of the router, see
+// httprouter.TestRouterZeroAllocs. Pooling contexts and params saves some, not all of them, the
+// remaining ones are:
+//   - 2 for the request id, by uuid.NewV4 and UUID.String
+//   - 1 for the value of the X-Request-Id header
+//   - 7 for the arguments of the request start and complete log lines
+const serveAllocs = 10
+
+func TestServeAllocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping malloc count in short mode")
+	}
+	if raceEnabled {
+		t.Skip("skipping malloc count with the race detector")
+	}
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	c := New(&JSONEncoder{})
+	c.Get("/users/new", func(ctx *Context) {
+		ctx.ServeStatus(http.StatusOK)
+	})
+	c.Get("/users/:id/posts/:post", func(ctx *Context) {
+		ctx.ServeStatus(http.StatusOK)
+	})
+
+	w := &discardResponseWriter{header: http.Header{}}
+	for _, path := range []string{"/users/new", "/users/42/posts/7"} {
+		r := newRequest("GET", path, nil)
+		allocs := testing.AllocsPerRun(100, func() { c.ServeHTTP(w, r) })
+		if allocs > serveAllocs {
+			t.Errorf("%s: %v allocs, want at most %d", path, allocs, serveAllocs)
+		}
+	}
+}
@@ -7,11 +7,16 @@
 package cobalt
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"reflect"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"bitbucket.org/ardanlabs/cobalt/httprouter"
@@ -31,7 +36,15 @@ type (
 		router      *httprouter.Router
 		global      []MiddleWare
 		serverError Handler
+		notFound    Handler
 		coder       Coder
+		// named routes, guarded by mu as routes can be added and removed at runtime
+		mu    sync.RWMutex
+		names map[string]*Route
+		// contexts are reused for the requests, see Context
+		contexts sync.Pool
+		// servers and shutdown, see Serve
+		life lifecycle
 	}
 
 	// Handler represents a request handler that is called by cobalt
@@ -39,11 +52,51 @@ type (
 
 	// MiddleWare is the type for middleware.
 	MiddleWare func(Handler) Handler
+
+	// Route describes a route registered with cobalt.
+	Route struct {
+		Name    string
+		Method  string
+		Pattern string
+		Params  []string
+		// names of the handler and the route specific middleware funcs
+		Handler    string
+		MiddleWare []string
+	}
+
+	// RedirectPolicy configures the redirects made if no route matches a request, but a route would
+	// with a trailing slash added or removed (TrailingSlash), or with the path cleaned and its case
+	// corrected (FixedPath). Code is the status code for GET requests and MethodCode the one for all
+	// other methods, which must preserve the method, i.e. 307 or 308. They default to 301 and 307.
+	// With Serve the corrected path is served right away instead of redirecting to it, see
+	// Context.OriginalPath.
+	RedirectPolicy struct {
+		TrailingSlash bool
+		FixedPath     bool
+		Code          int
+		MethodCode    int
+		Serve         bool
+	}
 )
 
 // New creates a new instance of cobalt.
 func New(coder Coder) *Cobalt {
-	return &Cobalt{router: httprouter.New(), coder: coder}
+	c := &Cobalt{router: httprouter.New(), coder: coder}
+
+	// the responses made by the router go through the same handling as routed requests
+	c.NotFound(statusHandler(http.StatusNotFound))
+	c.MethodNotAllowed(statusHandler(http.StatusMethodNotAllowed))
+	c.GlobalOptions(func(ctx *Context) {
+		ctx.ServeStatus(http.StatusOK)
+	})
+	c.NotAcceptable(statusHandler(http.StatusNotAcceptable))
+	c.router.Redirect = c.redirect
+
+	c.life.done = make(chan struct{})
+	c.life.drain = defaultDrainTimeout
+	c.life.signals = defaultSignals
+
+	return c
 }
 
 // Coder returns the Coder configured in Cobalt
@@ -58,59 +111,321 @@ func (c *Cobalt) ServerErr(h Handler) {
 
 // NotFound sets a not found handler.
 func (c *Cobalt) NotFound(h Handler) {
-	t := func(w http.ResponseWriter, req *http.Request) {
-		ctx := NewContext(req, w, nil, c.coder)
-		h(ctx)
+	c.notFound = h
+	c.router.NotFound = c.routerHandler(h)
+}
+
+// MethodNotAllowed sets the handler for requests which match a route, but not its method. The
+// Allow header listing the methods of the route is set before it is called.
+func (c *Cobalt) MethodNotAllowed(h Handler) {
+	c.router.MethodNotAllowed = c.routerHandler(h)
+}
+
+// NotAcceptable sets the handler for requests which match a route registered with HandleMatch, but
+// none of its handlers, if the route has no handler without matchers.
+func (c *Cobalt) NotAcceptable(h Handler) {
+	c.router.NotAcceptable = c.routerHandler(h)
+}
+
+// GlobalOptions sets a handler for the automatic replies to OPTIONS requests, e.g. to add CORS
+// headers. The Allow header listing the methods of the requested route is set before it is called.
+func (c *Cobalt) GlobalOptions(h Handler) {
+	c.router.GlobalOPTIONS = c.routerHandler(h)
+}
+
+// RawPath enables matching the escaped path of requests, so that encoded slashes like in
+// /objects/a%2Fb don't separate segments. Parameter values are unescaped after matching.
+func (c *Cobalt) RawPath(enable bool) {
+	c.router.UseRawPath = enable
+}
+
+// HeadFallback enables serving HEAD requests by the GET handler of a route, unless a HEAD handler is
+// registered for it. The body written by the handler is discarded, its headers are kept.
+func (c *Cobalt) HeadFallback(enable bool) {
+	c.router.HandleHEAD = enable
+}
+
+// Redirects configures the redirects made for requests which match a route only with a corrected
+// path. By default both kinds of redirects are made, with the status codes 301 and 307.
+func (c *Cobalt) Redirects(p RedirectPolicy) {
+	c.router.RedirectTrailingSlash = p.TrailingSlash
+	c.router.RedirectFixedPath = p.FixedPath
+	c.router.RedirectCode = p.Code
+	c.router.RedirectMethodCode = p.MethodCode
+	c.router.ServeFixedPath = p.Serve
+}
+
+// Handle adds a route with an associated method, handler and route filters. Unlike the
+// method specific helpers it returns an error instead of panicking if the route can't be registered.
+func (c *Cobalt) Handle(method, route string, h Handler, m ...MiddleWare) error {
+	return c.HandleNamed("", method, route, h, m...)
+}
+
+// HandleNamed is like Handle, but registers the route with a name, which can be used to build URLs
+// for the route with URL. Names must be unique.
+func (c *Cobalt) HandleNamed(name, method, route string, h Handler, m ...MiddleWare) error {
+	return c.handle(name, method, route, nil, h, m)
+}
+
+// HandleMatch is like Handle, but the handler only serves requests which match all matchers, e.g.
+// httprouter.MediaType("application/vnd.acme.v2+json") or httprouter.Header("Api-Version", "2").
+// Several handlers can be registered for the same method and route this way. A handler registered
+// for the route without matchers serves the requests none of the others matches, without it
+// they are answered with 406 Not Acceptable.
+func (c *Cobalt) HandleMatch(method, route string, matchers []httprouter.Matcher, h Handler, m ...MiddleWare) error {
+	return c.handle("", method, route, matchers, h, m)
+}
+
+// handle registers a route, see HandleNamed and HandleMatch.
+func (c *Cobalt) handle(name, method, route string, matchers []httprouter.Matcher, h Handler, m []MiddleWare) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r, ok := c.names[name]; ok {
+		return fmt.Errorf("%s %s: route name %q is already used (registered route '%s')", method, route, name, r.Pattern)
 	}
 
-	c.router.NotFound = http.HandlerFunc(t)
+	info := &Route{Name: name, Method: method, Pattern: route, Handler: funcName(h)}
+	for idx := range m {
+		info.MiddleWare = append(info.MiddleWare, funcName(m[idx]))
+	}
+
+	if err := c.router.RegisterMatch(method, route, c.handler(route, h, m), info, matchers...); err != nil {
+		return err
+	}
+
+	if name != "" {
+		if c.names == nil {
+			c.names = make(map[string]*Route)
+		}
+		c.names[name] = info
+	}
+	return nil
 }
 
-// Route adds a route with an asscoiated method, handler and route filters.. It Builds a function which is then passed to the router.
-func (c *Cobalt) route(method, route string, h Handler, m []MiddleWare) {
+// mountParam is the name of the catch-all parameter of the routes registered by Mount.
+const mountParam = "mountpath"
+
+// mountMethods are the methods Mount registers routes for.
+var mountMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE"}
+
+// Mount serves the requests for the prefix and all paths below it by h, whatever their method. It
+// can mount e.g. net/http/pprof, a metrics handler or another Cobalt app. The prefix is stripped
+// from the path of the request passed to h, so with the prefix /admin the request /admin/users is
+// passed on as /users and /admin as /. The request goes through the middleware and panic handling
+// like any other, a mounted Cobalt app uses the same request id and a copy of the data, see HTTPHandler.
+func (c *Cobalt) Mount(prefix string, h http.Handler, m ...MiddleWare) error {
+	if !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("mount prefix %q must begin with '/'", prefix)
+	}
+	prefix = strings.TrimRight(prefix, "/")
 
-	f := func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
-		st := time.Now()
-		ctx := NewContext(req, w, p, c.coder)
-
-		// Handle panics
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("cobalt: Panic, Recovering\n")
-				buf := make([]byte, 10000)
-				runtime.Stack(buf, false)
-				log.Printf("%s\n", string(buf))
-				if c.serverError != nil {
-					c.serverError(ctx)
-					return
+	mounted := func(ctx *Context) {
+		req := withContext(ctx.Request, ctx)
+		u := *req.URL
+		u.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(u.Path, prefix), "/")
+		if u.RawPath != "" {
+			u.RawPath = "/" + strings.TrimPrefix(strings.TrimPrefix(u.RawPath, prefix), "/")
+		}
+		req.URL = &u
+		h.ServeHTTP(ctx.Response, req)
+	}
+
+	routes := []string{prefix + "/*" + mountParam}
+	if prefix != "" {
+		routes = append(routes, prefix)
+	}
+	return c.handleAll(mountMethods, routes, mounted, m)
+}
+
+// handleAll registers the handler for all combinations of the methods and routes. If one of them
+// fails, the ones registered before are removed again.
+func (c *Cobalt) handleAll(methods, routes []string, h Handler, m []MiddleWare) error {
+	var registered [][2]string
+	for _, route := range routes {
+		for _, method := range methods {
+			if err := c.handle("", method, route, nil, h, m); err != nil {
+				for _, r := range registered {
+					c.Remove(r[0], r[1])
 				}
+				return err
 			}
+			registered = append(registered, [2]string{method, route})
+		}
+	}
+	return nil
+}
+
+// Remove removes the route registered with the method and route pattern. Routes can be added and
+// removed while cobalt serves requests, each request is routed with the routes as they were when
+// it arrived.
+func (c *Cobalt) Remove(method, route string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-			log.Printf("Request %s complete [%s] =>  %s %s - %s", ctx.ID, time.Since(st), req.Method, req.RequestURI, req.RemoteAddr)
-		}()
+	if err := c.router.Remove(method, route); err != nil {
+		return err
+	}
 
-		log.Printf("Request %s start =>  %s %s - %s", ctx.ID, req.Method, req.RequestURI, req.RemoteAddr)
+	for name, r := range c.names {
+		if r.Method == method && r.Pattern == route {
+			delete(c.names, name)
+		}
+	}
+	return nil
+}
 
-		w.Header().Set("X-Request-Id", ctx.ID)
+// URL builds the path for the named route by filling in the parameters, which are given as key
+// value pairs, e.g. URL("user", "id", "42") for the route /users/:id returns /users/42. Values are
+// escaped, the value of a catch-all parameter may contain slashes. For a route with a host it returns
+// a network-path reference like //acme.example.com/users/42. It returns an error if the name is
+// unknown, if a parameter of the route is missing or if a parameter is not part of the route.
+func (c *Cobalt) URL(name string, params ...string) (string, error) {
+	c.mu.RLock()
+	r, ok := c.names[name]
+	c.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown route name %q", name)
+	}
+	if len(params)%2 != 0 {
+		return "", errors.New("params must be given as key value pairs")
+	}
 
-		mwchain := func(h Handler) Handler {
-			// global middleware.
-			for idx := range c.global {
-				h = c.global[idx](h)
-			}
+	ps := make(httprouter.Params, 0, len(params)/2)
+	for idx := 0; idx < len(params); idx += 2 {
+		ps = append(ps, httprouter.Param{Key: params[idx], Value: params[idx+1]})
+	}
+	url, err := httprouter.BuildPath(r.Pattern, ps)
+	if err != nil || url[0] == '/' {
+		return url, err
+	}
+
+	// a network-path reference for a route with a host
+	return "//" + url, nil
+}
+
+// Validate checks whether the given routes can be registered, without registering them. It
+// reports every malformed route and every conflict with registered routes or between the routes.
+func (c *Cobalt) Validate(routes []httprouter.Route) error {
+	return c.router.Validate(routes)
+}
+
+// Routes returns all registered routes ordered by method and pattern. It can be used to print
+// the route table or to check in tests that each route has the middleware it needs.
+func (c *Cobalt) Routes() []Route {
+	var routes []Route
+	c.router.Walk(func(r httprouter.Route, _ httprouter.Handle) error {
+		if info, ok := r.Meta.(*Route); ok {
+			route := *info
+			route.Method = r.Method
+			route.Pattern = r.Path
+			route.Params = r.Params
+			routes = append(routes, route)
+		}
+		return nil
+	})
+	return routes
+}
+
+// Route adds a route with an asscoiated method, handler and route filters..
+func (c *Cobalt) route(method, route string, h Handler, m []MiddleWare) {
+	if err := c.Handle(method, route, h, m...); err != nil {
+		panic(err.Error())
+	}
+}
 
-			// route specific middleware
-			for idx := range m {
-				h = m[idx](h)
+// funcName returns the name of the func f as known to the runtime, e.g. main.Auth.func1 for a
+// closure returned by main.Auth.
+func funcName(f interface{}) string {
+	v := reflect.ValueOf(f)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return ""
+	}
+	return runtime.FuncForPC(v.Pointer()).Name()
+}
+
+// handler builds the function which is passed to the router for a handler and its route filters.
+// The route pattern is known at this point, so it is passed on to the context of each request.
+func (c *Cobalt) handler(route string, h Handler, m []MiddleWare) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		c.serve(w, req, p, route, h, m)
+	}
+}
+
+// routerHandler builds the handler which is passed to the router for the responses it makes
+// itself, e.g. if no route matches.
+func (c *Cobalt) routerHandler(h Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.serve(w, req, nil, "", h, nil)
+	})
+}
+
+// redirect makes the redirects of the router.
+func (c *Cobalt) redirect(w http.ResponseWriter, req *http.Request, url string, code int) {
+	c.serve(w, req, nil, "", func(ctx *Context) {
+		ctx.Response.Header().Set("Location", url)
+		ctx.ServeStatus(code)
+	}, nil)
+}
+
+// statusHandler returns a handler which serves the text of the status code, it is the default for
+// the responses made by the router.
+func statusHandler(status int) Handler {
+	return func(ctx *Context) {
+		ctx.ServeWithStatus(http.StatusText(status), status)
+	}
+}
+
+// serve processes a request with the handler and its route filters.
+func (c *Cobalt) serve(w http.ResponseWriter, req *http.Request, p httprouter.Params, route string, h Handler, m []MiddleWare) {
+	st := time.Now()
+	ctx, _ := c.contexts.Get().(*Context)
+	if ctx == nil {
+		ctx = &Context{data: make(map[string]interface{})}
+	}
+	ctx.reset(req, w, p, c.coder)
+	ctx.pattern = route
+	defer func() {
+		if !ctx.detached {
+			c.contexts.Put(ctx)
+		}
+	}()
+
+	// Handle panics
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("cobalt: Panic, Recovering\n")
+			buf := make([]byte, 10000)
+			runtime.Stack(buf, false)
+			log.Printf("%s\n", string(buf))
+			if c.serverError != nil {
+				c.serverError(ctx)
+				return
 			}
-			return h
 		}
 
-		// process request
-		mwchain(h)(ctx)
+		log.Printf("Request %s complete [%s] =>  %s %s - %s", ctx.ID, time.Since(st), req.Method, req.RequestURI, req.RemoteAddr)
+	}()
+
+	log.Printf("Request %s start =>  %s %s - %s", ctx.ID, req.Method, req.RequestURI, req.RemoteAddr)
+
+	w.Header().Set("X-Request-Id", ctx.ID)
+
+	mwchain := func(h Handler) Handler {
+		// global middleware.
+		for idx := range c.global {
+			h = c.global[idx](h)
+		}
+
+		// route specific middleware
+		for idx := range m {
+			h = m[idx](h)
+		}
+		return h
 	}
 
-	c.router.Handle(method, route, f)
+	// process request
+	mwchain(h)(ctx)
 }
 
 // Get adds a route with an associated handler that matches a GET verb in a request.
@@ -148,15 +463,15 @@ func (c *Cobalt) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	c.router.ServeHTTP(w, req)
 }
 
-// Run runs the dispatcher which starts an http server to listen and serve.
+// Run runs the dispatcher which starts an http server to listen and serve. It shuts down gracefully on
+// SIGINT and SIGTERM, but exits if the server fails, see Serve for more control and ServeTLS for TLS.
 func (c *Cobalt) Run(addr string) {
 	log.SetOutput(os.Stdout)
 	log.SetFlags(0)
 	log.SetPrefix("[cobalt] ")
 	log.Printf("starting, listening on %s", addr)
 
-	// TODO: add support for SSL/TLS
-	err := http.ListenAndServe(addr, c)
+	err := c.Serve(&http.Server{Addr: addr})
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
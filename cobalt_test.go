@@ -1,7 +1,9 @@
 package cobalt
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,7 +11,9 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
+	"bitbucket.org/ardanlabs/cobalt/httprouter"
 	"gopkg.in/vmihailenco/msgpack.v2"
 )
 
@@ -173,6 +177,229 @@ func AssertRoute(path, verb string, c *Cobalt, t *testing.T) {
 	}
 }
 
+// TestHandleError tests registering conflicting routes.
+func TestHandleError(t *testing.T) {
+	c := New(&JSONEncoder{})
+
+	h := func(ctx *Context) {}
+	if err := c.Handle("GET", "/users/:id", h); err != nil {
+		t.Fatalf("expected no error instead got %v", err)
+	}
+	if err := c.Handle("GET", "/users/:name/posts", h); err == nil {
+		t.Errorf("expected an error for a conflicting route")
+	}
+}
+
+// TestValidate tests validating routes before registering them.
+func TestValidate(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.Get("/users/:id", func(ctx *Context) {})
+
+	err := c.Validate([]httprouter.Route{
+		{Method: "GET", Path: "/users/:name"},
+		{Method: "POST", Path: "/users"},
+		{Method: "POST", Path: "users"},
+	})
+	errs, ok := err.(httprouter.RouteErrors)
+	if !ok {
+		t.Fatalf("expected httprouter.RouteErrors instead got %T", err)
+	}
+	if len(errs) != 2 {
+		t.Errorf("expected 2 errors instead got %d: %v", len(errs), err)
+	}
+}
+
+func authMiddleware(h Handler) Handler {
+	return h
+}
+
+func listUsers(ctx *Context) {}
+
+// TestRouteList tests listing the registered routes.
+func TestRouteList(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.Get("/users", listUsers)
+	c.Delete("/users/:id", listUsers, authMiddleware)
+	c.Get("/users/:id/posts/:post", func(ctx *Context) {})
+
+	routes := c.Routes()
+	if len(routes) != 3 {
+		t.Fatalf("expected 3 routes instead got %d", len(routes))
+	}
+
+	r := routes[0]
+	if r.Method != "DELETE" || r.Pattern != "/users/:id" || len(r.Params) != 1 || r.Params[0] != "id" {
+		t.Errorf("unexpected route %+v", r)
+	}
+	if !strings.HasSuffix(r.Handler, ".listUsers") {
+		t.Errorf("expected handler listUsers instead got %s", r.Handler)
+	}
+	if len(r.MiddleWare) != 1 || !strings.HasSuffix(r.MiddleWare[0], ".authMiddleware") {
+		t.Errorf("expected middleware authMiddleware instead got %v", r.MiddleWare)
+	}
+
+	r = routes[2]
+	if r.Method != "GET" || r.Pattern != "/users/:id/posts/:post" || len(r.Params) != 2 || len(r.MiddleWare) != 0 {
+		t.Errorf("unexpected route %+v", r)
+	}
+}
+
+// TestURL tests building URLs for named routes.
+func TestURL(t *testing.T) {
+	c := New(&JSONEncoder{})
+	if err := c.HandleNamed("user", "GET", "/users/:id{int}", listUsers); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.HandleNamed("files", "GET", "/files/*path", listUsers); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.HandleNamed("user", "GET", "/people/:id", listUsers); err == nil {
+		t.Error("expected an error for a duplicate route name")
+	}
+
+	tests := []struct {
+		name   string
+		params []string
+		url    string
+	}{
+		{"user", []string{"id", "42"}, "/users/42"},
+		{"files", []string{"path", "/docs/read me.txt"}, "/files/docs/read%20me.txt"},
+		{"user", []string{"id", "x"}, ""},
+		{"user", []string{"id"}, ""},
+		{"user", nil, ""},
+		{"user", []string{"id", "42", "page", "2"}, ""},
+		{"unknown", nil, ""},
+	}
+	for _, tt := range tests {
+		u, err := c.URL(tt.name, tt.params...)
+		if tt.url == "" {
+			if err == nil {
+				t.Errorf("URL(%q, %v): expected an error instead got %s", tt.name, tt.params, u)
+			}
+			continue
+		}
+		if err != nil || u != tt.url {
+			t.Errorf("URL(%q, %v): expected %s instead got %s (%v)", tt.name, tt.params, tt.url, u, err)
+		}
+	}
+
+	if r := c.Routes()[0]; r.Name != "files" {
+		t.Errorf("expected route name files instead got %q", r.Name)
+	}
+}
+
+// TestHost tests routing by host.
+func TestHost(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.HandleNamed("user", "GET", ":tenant.example.com/users/:id", func(ctx *Context) {
+		ctx.Response.Write([]byte(ctx.ParamValue("tenant") + " " + ctx.ParamValue("id")))
+	})
+
+	r := newRequest("GET", "/users/42", nil)
+	r.Host = "acme.example.com"
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, r)
+	if w.Body.String() != "acme 42" {
+		t.Errorf("expected body to be acme 42 instead got %s", w.Body.String())
+	}
+
+	u, err := c.URL("user", "tenant", "acme", "id", "42")
+	if err != nil || u != "//acme.example.com/users/42" {
+		t.Errorf("expected //acme.example.com/users/42 instead got %s (%v)", u, err)
+	}
+}
+
+// TestHandleMatch tests choosing handlers by matchers.
+func TestHandleMatch(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.Get("/users/:id", func(ctx *Context) {
+		ctx.Response.Write([]byte("v1"))
+	})
+	err := c.HandleMatch("GET", "/users/:id", []httprouter.Matcher{httprouter.MediaType("application/vnd.acme.v2+json")}, func(ctx *Context) {
+		ctx.Response.Write([]byte("v2"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for accept, body := range map[string]string{"application/json": "v1", "application/vnd.acme.v2+json": "v2"} {
+		r := newRequest("GET", "/users/42", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+		if w.Body.String() != body {
+			t.Errorf("expected body to be %s for %s instead got %s", body, accept, w.Body.String())
+		}
+	}
+}
+
+// TestRemove tests removing routes at runtime.
+func TestRemove(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.HandleNamed("user", "GET", "/users/:id", func(ctx *Context) {
+		ctx.Response.Write([]byte("user"))
+	})
+
+	if err := c.Remove("GET", "/users/:id"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Remove("GET", "/users/:id"); err == nil {
+		t.Error("expected an error removing an unknown route")
+	}
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/users/42", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status code to be 404 instead got %d", w.Code)
+	}
+	if _, err := c.URL("user", "id", "42"); err == nil {
+		t.Error("expected the route name to be removed")
+	}
+	if err := c.HandleNamed("user", "GET", "/people/:id", listUsers); err != nil {
+		t.Errorf("expected the route name to be reusable instead got %v", err)
+	}
+}
+
+// TestRoutePattern tests that the matched route pattern is passed to middleware and handlers.
+func TestRoutePattern(t *testing.T) {
+	var mw, h string
+	c := New(&JSONEncoder{})
+	c.Get("/users/:id", func(ctx *Context) {
+		h = ctx.RoutePattern()
+	}, func(next Handler) Handler {
+		return func(ctx *Context) {
+			mw = ctx.RoutePattern()
+			next(ctx)
+		}
+	})
+
+	c.ServeHTTP(httptest.NewRecorder(), newRequest("GET", "/users/42", nil))
+
+	if mw != "/users/:id" || h != "/users/:id" {
+		t.Errorf("expected pattern /users/:id instead got %q in middleware and %q in handler", mw, h)
+	}
+}
+
+// TestGlobalOptions tests the handler for automatic OPTIONS replies.
+func TestGlobalOptions(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.Get("/users/:id", listUsers)
+	c.Delete("/users/:id", listUsers)
+	c.GlobalOptions(func(ctx *Context) {
+		ctx.Response.Header().Set("Access-Control-Allow-Methods", ctx.Response.Header().Get("Allow"))
+	})
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("OPTIONS", "/users/42", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status code to be 200 instead got %d", w.Code)
+	}
+	if m := w.Header().Get("Access-Control-Allow-Methods"); m != "DELETE, GET, OPTIONS" {
+		t.Errorf("expected allowed methods DELETE, GET, OPTIONS instead got %s", m)
+	}
+}
+
 // TestNotFoundHandler tests handler for 404.
 func TestNotFoundHandler(t *testing.T) {
 	//setup request
@@ -208,6 +435,334 @@ func TestNotFoundHandler(t *testing.T) {
 	}
 }
 
+// TestMethodNotAllowedHandler tests handler for 405.
+func TestMethodNotAllowedHandler(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.Post("/users", listUsers)
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/users", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("X-Request-Id") == "" {
+		t.Errorf("expected status code 405 with request id instead got %d %v", w.Code, w.Header())
+	}
+	if allow := w.Header().Get("Allow"); allow != "OPTIONS, POST" {
+		t.Errorf("expected Allow header OPTIONS, POST instead got %s", allow)
+	}
+	var msg string
+	if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil || msg != "Method Not Allowed" {
+		t.Errorf("expected encoded status text instead got %s", w.Body.String())
+	}
+
+	c.MethodNotAllowed(func(ctx *Context) {
+		ctx.ServeStatus(http.StatusTeapot)
+	})
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/users", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("expected status code to be 418 instead got %d", w.Code)
+	}
+}
+
+// TestOptional tests routes with optional segments and default values.
+func TestOptional(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.Get("/items/:page?=1", func(ctx *Context) {
+		ctx.Serve(ctx.ParamValue("page"))
+	})
+
+	for path, want := range map[string]string{"/items": `"1"`, "/items/3": `"3"`} {
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, newRequest("GET", path, nil))
+		if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != want {
+			t.Errorf("%s: expected page %s instead got %d %q", path, want, w.Code, w.Body.String())
+		}
+	}
+}
+
+// TestRawPath tests matching escaped paths.
+func TestRawPath(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.Get("/objects/:key", func(ctx *Context) {
+		ctx.Serve(ctx.ParamValue("key"))
+	})
+	c.RawPath(true)
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/objects/a%2Fb", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `"a/b"` {
+		t.Errorf("expected the key a/b instead got %d %q", w.Code, w.Body.String())
+	}
+}
+
+// TestHeadFallback tests serving HEAD requests by GET handlers.
+func TestHeadFallback(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.Get("/users", func(ctx *Context) {
+		ctx.Serve([]string{"gopher"})
+	})
+	c.HeadFallback(true)
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("HEAD", "/users", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("expected status code 200 without body instead got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != c.Coder().ContentType() || w.Header().Get("Content-Length") == "" {
+		t.Errorf("expected the headers of the GET handler instead got %v", w.Header())
+	}
+}
+
+// TestRedirects tests the redirects for paths with a trailing slash.
+func TestRedirects(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.Post("/users", listUsers)
+	c.Redirects(RedirectPolicy{TrailingSlash: true, MethodCode: http.StatusPermanentRedirect})
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("POST", "/users/", nil))
+	if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "/users" {
+		t.Errorf("expected redirect to /users with 308 instead got %d %v", w.Code, w.Header())
+	}
+	if w.Header().Get("X-Request-Id") == "" {
+		t.Error("expected request id for redirect")
+	}
+
+	// fixed paths are disabled
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("POST", "/USERS", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status code to be 404 instead got %d", w.Code)
+	}
+
+	// serve the corrected path right away
+	var path, original string
+	c.Put("/users", func(ctx *Context) {
+		path, original = ctx.Request.URL.Path, ctx.OriginalPath()
+		if params := ctx.Params(); len(params) != 0 {
+			t.Errorf("expected no params instead got %v", params)
+		}
+		ctx.ServeStatus(http.StatusNoContent)
+	})
+	c.Redirects(RedirectPolicy{TrailingSlash: true, FixedPath: true, Serve: true})
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("PUT", "/Users/", nil))
+	if w.Code != http.StatusNoContent || path != "/users" || original != "/Users/" {
+		t.Errorf("expected /Users/ to be served as /users instead got %d %q %q", w.Code, path, original)
+	}
+}
+
+// TestMount tests serving a prefix by http.Handlers and sub-apps.
+func TestMount(t *testing.T) {
+	c := New(&JSONEncoder{})
+
+	var path, id string
+	err := c.Mount("/debug/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, id = r.URL.Path, RequestID(r.Context())
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for req, want := range map[string]string{"/debug": "/", "/debug/": "/", "/debug/pprof/heap": "/pprof/heap"} {
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, newRequest("DELETE", req, nil))
+		if w.Code != http.StatusAccepted || path != want {
+			t.Errorf("%s: expected %s to be served instead got %d %q", req, want, w.Code, path)
+		}
+		if id == "" || id != w.Header().Get("X-Request-Id") {
+			t.Errorf("%s: expected request id %q instead got %q", req, w.Header().Get("X-Request-Id"), id)
+		}
+	}
+
+	// sub-app with the request id of the mounting app
+	sub := New(&JSONEncoder{})
+	sub.Get("/users", func(ctx *Context) {
+		path, id = ctx.Request.URL.Path, ctx.ID
+		ctx.Serve("users")
+	})
+	if err := c.Mount("/admin", sub); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/admin/users", nil))
+	if w.Code != http.StatusOK || path != "/users" || id != w.Header().Get("X-Request-Id") {
+		t.Errorf("expected /users with the id %q instead got %d %q %q", w.Header().Get("X-Request-Id"), w.Code, path, id)
+	}
+
+	// panics are handled by the mounting app
+	c.ServerErr(func(ctx *Context) { ctx.ServeStatus(http.StatusInternalServerError) })
+	c.Mount("/panic", http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("mounted") }))
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/panic/x", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status code to be 500 instead got %d", w.Code)
+	}
+
+	if err := c.Mount("debug", sub); err == nil {
+		t.Error("expected error for prefix without leading slash")
+	}
+	if err := c.Mount("/admin", sub); err == nil {
+		t.Error("expected error for mounting a prefix twice")
+	}
+}
+
+// TestHTTPMiddleWare tests net/http middleware adapted to MiddleWare.
+func TestHTTPMiddleWare(t *testing.T) {
+	type userKey struct{}
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Seen-Id", RequestID(r.Context()))
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, "gopher")))
+		})
+	}
+
+	c := New(&JSONEncoder{})
+	c.Get("/", func(ctx *Context) {
+		ctx.Serve(ctx.Request.Context().Value(userKey{}))
+	}, HTTPMiddleWare(mw))
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/", nil))
+	if strings.TrimSpace(w.Body.String()) != `"gopher"` {
+		t.Errorf("expected the request of the middleware instead got %q", w.Body.String())
+	}
+	if id := w.Header().Get("X-Seen-Id"); id == "" || id != w.Header().Get("X-Request-Id") {
+		t.Errorf("expected the middleware to see the request id instead got %q", id)
+	}
+}
+
+// TestHTTPHandler tests serving cobalt handlers as http.Handler.
+func TestHTTPHandler(t *testing.T) {
+	c := New(&JSONEncoder{})
+	h := c.HTTPHandler(func(ctx *Context) {
+		user, _ := ctx.GetData("user").(string)
+		ctx.Serve(user)
+	})
+
+	// served by net/http
+	mux := http.NewServeMux()
+	mux.Handle("/me", h)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, newRequest("GET", "/me", nil))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `""` || w.Header().Get("X-Request-Id") == "" {
+		t.Errorf("expected a request without data instead got %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	// id and data of a net/http middleware in a cobalt app
+	var id string
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id = RequestID(r.Context())
+			if !SetData(r.Context(), "user", "gopher") {
+				t.Error("expected the request to belong to a cobalt request")
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	c.Get("/me", func(ctx *Context) {
+		h.ServeHTTP(ctx.Response, ctx.Request)
+	}, HTTPMiddleWare(mw))
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/me", nil))
+	if strings.TrimSpace(w.Body.String()) != `"gopher"` || id != w.Header().Get("X-Request-Id") {
+		t.Errorf("expected the data and id %q instead got %q %q", w.Header().Get("X-Request-Id"), w.Body.String(), id)
+	}
+
+	if GetData(context.Background(), "user") != nil || SetData(context.Background(), "user", "gopher") {
+		t.Error("expected no data without cobalt request")
+	}
+}
+
+// closingWriter wraps a response writer like a compressing middleware, it fails once closed.
+type closingWriter struct {
+	http.ResponseWriter
+	closed bool
+}
+
+func (w *closingWriter) Write(b []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("closed writer")
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// TestHTTPMiddleWareRestore tests restoring the response writer and request after an adapted
+// middleware returns.
+func TestHTTPMiddleWareRestore(t *testing.T) {
+	wrap := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cw := &closingWriter{ResponseWriter: w}
+			defer func() { cw.closed = true }()
+			next.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), closingWriter{}, true)))
+		})
+	}
+
+	var writeErr error
+	var wrapped bool
+	outer := func(h Handler) Handler {
+		return func(ctx *Context) {
+			h(ctx)
+			if _, ok := ctx.Response.(*closingWriter); ok {
+				wrapped = true
+			}
+			if ctx.Request.Context().Value(closingWriter{}) != nil {
+				wrapped = true
+			}
+			_, writeErr = ctx.Response.Write([]byte(" after"))
+		}
+	}
+
+	c := New(&JSONEncoder{})
+	c.Get("/", func(ctx *Context) {
+		ctx.Response.Write([]byte("handler"))
+	}, HTTPMiddleWare(wrap), outer)
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/", nil))
+	if wrapped || writeErr != nil {
+		t.Errorf("expected the original writer and request after the middleware instead got wrapped %v, %v", wrapped, writeErr)
+	}
+	if w.Body.String() != "handler after" {
+		t.Errorf("expected body %q instead got %q", "handler after", w.Body.String())
+	}
+}
+
+// TestHTTPMiddleWareTimeout tests a middleware which returns before the handler after it, the
+// context must not be reused for other requests while the handler still runs.
+func TestHTTPMiddleWareTimeout(t *testing.T) {
+	timeout := func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, time.Millisecond, "timeout")
+	}
+
+	release, done := make(chan struct{}), make(chan string, 1)
+	c := New(&JSONEncoder{})
+	c.Get("/slow", func(ctx *Context) {
+		<-release
+		ctx.SetData("user", "gopher")
+		ctx.ServeStatus(http.StatusOK)
+		done <- ctx.Request.URL.Path
+	}, HTTPMiddleWare(timeout))
+	c.Get("/fast", func(ctx *Context) {
+		ctx.SetData("user", "other")
+		ctx.ServeStatus(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/slow", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status code 503 instead got %d", w.Code)
+	}
+
+	close(release)
+	for i := 0; i < 10; i++ {
+		c.ServeHTTP(httptest.NewRecorder(), newRequest("GET", "/fast", nil))
+	}
+	if path := <-done; path != "/slow" {
+		t.Errorf("expected the request of the handler instead got %s", path)
+	}
+}
+
 // TestServerErrorHandler tests handler for 500.
 func TestServerErrorHandler(t *testing.T) {
 	//setup request
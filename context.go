@@ -1,9 +1,11 @@
 package cobalt
 
 import (
+	"crypto/x509"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"bitbucket.org/ardanlabs/cobalt/httprouter"
 	"bitbucket.org/ardanlabs/cobalt/uuid"
@@ -18,7 +20,10 @@ type (
 
 	// Context is the struct type that holds context data for a request.
 	// Context is scoped at request level, it is currently not Go routine safe for writes, so all writes
-	// to context should be done by 1 go routine
+	// to context should be done by 1 go routine.
+	// Contexts are reused for other requests once the handler returns, so neither the context nor its
+	// params must be kept after that, e.g. by a go routine. Copy the values needed longer.
+	// Reusing them saves allocating the context and its params, each request still gets a new ID.
 	Context struct {
 		ID       string
 		Response http.ResponseWriter
@@ -28,7 +33,12 @@ type (
 		data map[string]interface{}
 		// params are the request parameters from the http request
 		params httprouter.Params
-		coder  Coder
+		// pattern is the route pattern which matched the request, e.g. /users/:id
+		pattern string
+		coder   Coder
+		// detached is set if a handler may still use the context after the request was served, e.g.
+		// behind http.TimeoutHandler, so it must not be reused, see HTTPMiddleWare
+		detached bool
 	}
 )
 
@@ -46,11 +56,112 @@ func NewContext(req *http.Request, resp http.ResponseWriter, p httprouter.Params
 	}
 }
 
+// reset prepares a reused context for a request, like NewContext does for a new one.
+func (c *Context) reset(req *http.Request, resp http.ResponseWriter, p httprouter.Params, coder Coder) {
+	for key := range c.data {
+		delete(c.data, key)
+	}
+	if parent := fromRequest(req); parent != nil {
+		// the request was passed on by another cobalt handler, e.g. a mounting app
+		c.ID = parent.ID
+		for key, value := range parent.data {
+			c.data[key] = value
+		}
+	} else {
+		id, _ := uuid.NewV4()
+		c.ID = id.String()
+	}
+	c.Request = req
+	c.Response = resp
+	c.Status = 0
+	c.params = p
+	c.pattern = ""
+	c.coder = coder
+}
+
 // ParamValue returns the value for the associated key from the url parameters.
 func (c *Context) ParamValue(key string) string {
 	return c.params.ByName(key)
 }
 
+// LookupParam returns the value for the associated key from the url parameters and reports whether
+// the parameter is part of the route. Unlike ParamValue it tells a missing parameter from an empty one.
+func (c *Context) LookupParam(key string) (string, bool) {
+	return c.params.Get(key)
+}
+
+// ParamInt64 returns the url parameter as a base 10 integer. Like the other typed getters it returns a
+// *httprouter.ParamError if the parameter is missing or invalid, which describes the parameter and can
+// be served as is, e.g. ctx.Error(err.Error(), http.StatusBadRequest).
+func (c *Context) ParamInt64(key string) (int64, error) {
+	return c.params.Int64(key)
+}
+
+// ParamUint64 returns the url parameter as a base 10 unsigned integer.
+func (c *Context) ParamUint64(key string) (uint64, error) {
+	return c.params.Uint64(key)
+}
+
+// ParamBool returns the url parameter as a boolean, see strconv.ParseBool for the accepted values.
+func (c *Context) ParamBool(key string) (bool, error) {
+	return c.params.Bool(key)
+}
+
+// ParamUUID returns the url parameter as a UUID in its hex form.
+func (c *Context) ParamUUID(key string) (uuid.UUID, error) {
+	return c.params.UUID(key)
+}
+
+// ParamTime returns the url parameter as a time in the given layout, see time.Parse.
+func (c *Context) ParamTime(key, layout string) (time.Time, error) {
+	return c.params.Time(key, layout)
+}
+
+// Params returns the url parameters as a map from key to value, e.g. for logging.
+func (c *Context) Params() map[string]string {
+	return c.params.Map()
+}
+
+// OriginalPath returns the path of the request as sent by the client if it was corrected before it
+// was served, e.g. /Users/ for the route /users, see RedirectPolicy. The corrected path is the path
+// of the request URL. It returns an empty string if the path wasn't corrected.
+func (c *Context) OriginalPath() string {
+	return c.params.OriginalPath()
+}
+
+// RoutePattern returns the pattern of the route which matched the request, e.g. /users/:id for
+// the request /users/42. Unlike the request path it can be used to label logs and metrics by route.
+// It is empty if no route matched, e.g. in the not found handler.
+func (c *Context) RoutePattern() string {
+	return c.pattern
+}
+
+// PeerCertificate returns the certificate the client presented to authenticate the TLS connection,
+// if it was verified, e.g. with mutual TLS, see TLSConfig.ClientCAFile. It returns nil otherwise.
+func (c *Context) PeerCertificate() *x509.Certificate {
+	if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 || len(c.Request.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return c.Request.TLS.VerifiedChains[0][0]
+}
+
+// PeerIdentity returns the identity of the client the verified certificate was issued to, for
+// authorization: its first URI, e.g. a SPIFFE id, else its first DNS name, else its common name. It
+// returns an empty string if the client didn't present a verified certificate.
+func (c *Context) PeerIdentity() string {
+	cert := c.PeerCertificate()
+	switch {
+	case cert == nil:
+		return ""
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	default:
+		return cert.Subject.CommonName
+	}
+}
+
 // GetData returns the value for the specified key from the context data. Usually used by prefilters to pass data to the http handler
 // and post filters.
 func (c *Context) GetData(key string) interface{} {
@@ -68,7 +179,7 @@ func (c *Context) SetData(key string, value interface{}) {
 
 // Error returns an http Error with the specified Error string and code
 func (c *Context) Error(body interface{}, status int) {
-	c.serveEncoded(body, 0, status)
+	c.serveEncoded(body, status, 0)
 }
 
 // Decode decodes a reader into val
@@ -147,3 +147,43 @@ func Test_ContextServeMPack(t *testing.T) {
 		t.Fatalf("expected name to be %t instead got %t", is, response.Is)
 	}
 }
+
+func Test_ContextParams(t *testing.T) {
+	c := New(JSONEncoder{})
+
+	c.Get("/users/:id/active/:active", func(c *Context) {
+		id, err := c.ParamInt64("id")
+		if err != nil {
+			c.Error(err.Error(), http.StatusBadRequest)
+			return
+		}
+		active, err := c.ParamBool("active")
+		if err != nil {
+			c.Error(err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := c.LookupParam("name"); ok {
+			t.Error("expected the param name to be missing")
+		}
+		if params := c.Params(); len(params) != 2 || params["id"] != "42" {
+			t.Errorf("expected the params as a map instead got %v", params)
+		}
+		c.Serve(map[string]interface{}{"id": id, "active": active})
+	})
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/users/42/active/true", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status code to be %d instead got %d", http.StatusOK, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/users/gopher/active/true", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status code to be %d instead got %d", http.StatusBadRequest, w.Code)
+	}
+	var msg string
+	if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil || msg != "param 'id': 'gopher' is not a valid int64" {
+		t.Errorf("expected a descriptive error instead got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,91 @@
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+
+package httprouter
+
+import (
+	"net/http"
+	"testing"
+)
+
+var benchRoutes = []string{
+	"/",
+	"/users",
+	"/users/new",
+	"/users/:id",
+	"/users/:id/posts/:post",
+	"/users/:id{int}/avatar",
+	"/files/:name.:ext",
+	"/src/*filepath",
+	":tenant.example.com/orders/:order",
+}
+
+var benchRequests = []struct {
+	name, host, path string
+}{
+	{"Static", "", "/users/new"},
+	{"Param", "", "/users/42"},
+	{"Params", "", "/users/42/posts/7"},
+	{"Constraint", "", "/users/42/avatar"},
+	{"Segment", "", "/files/app.tar.gz"},
+	{"CatchAll", "", "/src/some/file.go"},
+	{"Host", "acme.example.com", "/orders/1"},
+}
+
+func benchRouter() *Router {
+	router := New()
+	handle := func(_ http.ResponseWriter, _ *http.Request, _ Params) {}
+	for _, route := range benchRoutes {
+		router.GET(route, handle)
+	}
+	return router
+}
+
+func BenchmarkRouter(b *testing.B) {
+	router := benchRouter()
+	w := new(mockResponseWriter)
+
+	for _, bench := range benchRequests {
+		r, _ := http.NewRequest("GET", bench.path, nil)
+		r.Host = bench.host
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				router.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func BenchmarkRouterParallel(b *testing.B) {
+	router := benchRouter()
+	w := new(mockResponseWriter)
+	r, _ := http.NewRequest("GET", "/users/42/posts/7", nil)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			router.ServeHTTP(w, r)
+		}
+	})
+}
+
+func TestRouterZeroAllocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping malloc count in short mode")
+	}
+	if raceEnabled {
+		t.Skip("skipping malloc count with the race detector")
+	}
+
+	router := benchRouter()
+	w := new(mockResponseWriter)
+	for _, test := range benchRequests {
+		r, _ := http.NewRequest("GET", test.path, nil)
+		r.Host = test.host
+		allocs := testing.AllocsPerRun(100, func() { router.ServeHTTP(w, r) })
+		if allocs > 0 {
+			t.Errorf("%s %s%s: %v allocs, want zero", test.name, test.host, test.path, allocs)
+		}
+	}
+}
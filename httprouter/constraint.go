@@ -0,0 +1,106 @@
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+
+package httprouter
+
+import (
+	"regexp"
+)
+
+// constraint restricts the values a named parameter matches.
+// It is declared in braces after the parameter name, e.g. :id{int}, and is
+// either the name of one of the predefined constraints or a regular
+// expression which must match the whole value.
+type constraint struct {
+	expr  string
+	match func(string) bool
+}
+
+// predefined constraints, selected by their name
+var constraints = map[string]func(string) bool{
+	"int":   isInt,
+	"uint":  isUint,
+	"alpha": isAlpha,
+	"alnum": isAlnum,
+	"hex":   isHex,
+	"uuid":  isUUID,
+}
+
+// newConstraint returns the constraint for the given expression, which is
+// either a predefined constraint name or a regular expression.
+func newConstraint(expr string) (*constraint, error) {
+	if match, ok := constraints[expr]; ok {
+		return &constraint{expr: expr, match: match}, nil
+	}
+
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return &constraint{expr: expr, match: re.MatchString}, nil
+}
+
+func isUint(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isInt(s string) bool {
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	return isUint(s)
+}
+
+func isAlpha(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i] | 0x20; c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlnum(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && ((c|0x20) < 'a' || (c|0x20) > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && ((c|0x20) < 'a' || (c|0x20) > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// isUUID reports whether s is a UUID in its canonical textual representation,
+// e.g. 6ba7b810-9dad-11d1-80b4-00c04fd430c8.
+func isUUID(s string) bool {
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return false
+	}
+	return isHex(s[0:8]) && isHex(s[9:13]) && isHex(s[14:18]) &&
+		isHex(s[19:23]) && isHex(s[24:])
+}
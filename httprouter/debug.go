@@ -0,0 +1,131 @@
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+
+package httprouter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// String returns the name of the node type.
+func (t nodeType) String() string {
+	switch t {
+	case static:
+		return "static"
+	case root:
+		return "root"
+	case param:
+		return "param"
+	case catchAll:
+		return "catchAll"
+	default:
+		return fmt.Sprintf("nodeType(%d)", uint8(t))
+	}
+}
+
+// PrintTree writes the tree of the routes registered for the method to w as
+// indented text, one node per line, e.g. to find out why a route doesn't
+// match as expected. A line shows the path fragment of the node, its type,
+// priority and maxParams, the indices of its static children, whether it has
+// wildcard children and the route of its handle, if any. The trees of host
+// patterns come first, each after a line with the host pattern in brackets,
+// followed by the tree of the routes without host.
+func (r *Router) PrintTree(w io.Writer, method string) error {
+	var buf bytes.Buffer
+	for _, h := range r.loadHosts() {
+		root := h.trees[method]
+		if root == nil {
+			continue
+		}
+		if h.host != "" {
+			fmt.Fprintf(&buf, "[%s]\n", h.host)
+		} else if buf.Len() > 0 {
+			buf.WriteString("[no host]\n")
+		}
+		root.print(&buf, 0)
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// PrintTreeDOT is like PrintTree, but writes the tree in the DOT language of
+// Graphviz, e.g. to render it with dot -Tsvg. The trees of host patterns are
+// drawn in clusters. Nodes with a handle have a double border, edges to static
+// children are labeled with their index.
+func (r *Router) PrintTreeDOT(w io.Writer, method string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "digraph %q {\n\tnode [shape=box, fontname=monospace];\n", method)
+	id := 0
+	for i, h := range r.loadHosts() {
+		root := h.trees[method]
+		if root == nil {
+			continue
+		}
+		indent := "\t"
+		if h.host != "" {
+			fmt.Fprintf(&buf, "\tsubgraph cluster_%d {\n\t\tlabel=%q;\n", i, h.host)
+			indent = "\t\t"
+		}
+		root.dot(&buf, indent, &id)
+		if h.host != "" {
+			buf.WriteString("\t}\n")
+		}
+	}
+	buf.WriteString("}\n")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// print writes the subtree of the node as indented text.
+func (n *node) print(buf *bytes.Buffer, depth int) {
+	buf.WriteString(strings.Repeat("  ", depth))
+	buf.WriteString(n.describe())
+	buf.WriteByte('\n')
+	for _, child := range n.children {
+		child.print(buf, depth+1)
+	}
+}
+
+// dot writes the subtree of the node as DOT statements and returns the id of
+// the node.
+func (n *node) dot(buf *bytes.Buffer, indent string, id *int) int {
+	self := *id
+	*id++
+
+	attrs := ""
+	if n.handle != nil {
+		attrs = ", peripheries=2"
+	}
+	fmt.Fprintf(buf, "%sn%d [label=%q%s];\n", indent, self, strings.Replace(n.describe(), " ", "\n", 1), attrs)
+
+	for i, child := range n.children {
+		c := child.dot(buf, indent, id)
+		if i < len(n.indices) {
+			fmt.Fprintf(buf, "%sn%d -> n%d [label=%q];\n", indent, self, c, n.indices[i:i+1])
+		} else {
+			fmt.Fprintf(buf, "%sn%d -> n%d [style=dashed];\n", indent, self, c)
+		}
+	}
+	return self
+}
+
+// describe returns the path fragment and the properties of the node.
+func (n *node) describe() string {
+	s := fmt.Sprintf("%q %s priority=%d maxParams=%d", n.path, n.nType, n.priority, n.maxParams)
+	if n.indices != "" {
+		s += fmt.Sprintf(" indices=%q", n.indices)
+	}
+	if n.wildChild {
+		s += " wildChild"
+	}
+	if n.handle != nil {
+		s += " handle=" + n.fullPath
+		if n.variants != nil {
+			s += fmt.Sprintf(" variants=%d", len(n.variants.list))
+		}
+	}
+	return s
+}
@@ -0,0 +1,304 @@
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+
+package httprouter
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hostRoutes holds the trees by method of the routes registered for a host
+// pattern. Like the trees, it is never modified once stored.
+type hostRoutes struct {
+	host   string   // host pattern, "" for the routes without host
+	labels []string // labels of the host pattern
+	wild   bool     // whether the host pattern has params or wildcards
+	trees  map[string]*node
+}
+
+// hostList holds the routes by host pattern. Exact hosts come first, then the
+// host patterns with params or wildcards, both in the order of registration.
+// The routes without host come last.
+type hostList []*hostRoutes
+
+// splitHost splits a route path like api.example.com/users into the host
+// pattern and the path. The host is empty if the path begins with '/'.
+func splitHost(path string) (host, p string) {
+	if i := strings.IndexByte(path, '/'); i > 0 {
+		return path[:i], path[i:]
+	}
+	return "", path
+}
+
+// parseHost splits a host pattern into its labels, which are either static,
+// a param like :tenant or the wildcard *. It reports false if the pattern is
+// malformed.
+func parseHost(host string) (labels []string, wild, ok bool) {
+	labels = strings.Split(host, ".")
+	for i, label := range labels {
+		switch {
+		case label == "*":
+			wild = true
+		case len(label) > 1 && label[0] == ':' && !strings.ContainsAny(label[1:], ":*{}"):
+			wild = true
+		case label == "" || strings.ContainsAny(label, ":*{}"):
+			return nil, false, false
+		default:
+			labels[i] = strings.ToLower(label)
+		}
+	}
+	return labels, wild, true
+}
+
+// hostParamNames returns the names of the params of a host pattern.
+func hostParamNames(labels []string) []string {
+	var names []string
+	for _, label := range labels {
+		if label[0] == ':' {
+			names = append(names, label[1:])
+		}
+	}
+	return names
+}
+
+// hostname returns the host of a request without the port and the trailing
+// dot of a fully qualified name.
+func hostname(host string) string {
+	if i := strings.LastIndexByte(host, ':'); i > strings.LastIndexByte(host, ']') {
+		host = host[:i]
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// match reports whether the host matches the host pattern and appends the
+// values of its params to ps. The routes without host match every host.
+func (h *hostRoutes) match(host string, ps Params) (Params, bool) {
+	for i, label := range h.labels {
+		value := host
+		if i < len(h.labels)-1 {
+			end := strings.IndexByte(host, '.')
+			if end < 0 {
+				return ps, false
+			}
+			value, host = host[:end], host[end+1:]
+		} else if strings.IndexByte(host, '.') >= 0 {
+			return ps, false
+		}
+
+		switch {
+		case value == "":
+			return ps, false
+		case label == "*":
+		case label[0] == ':':
+			ps = append(ps, Param{label[1:], value})
+		case !strings.EqualFold(label, value):
+			return ps, false
+		}
+	}
+	return ps, true
+}
+
+// find returns the routes registered for the host pattern, or nil.
+func (hs hostList) find(host string) *hostRoutes {
+	for _, h := range hs {
+		if h.host == host {
+			return h
+		}
+	}
+	return nil
+}
+
+// matching returns the routes of the host patterns which match the host of a
+// request, followed by the routes without host, in the order in which they
+// are tried.
+func (hs hostList) matching(host string) []*hostRoutes {
+	var matched []*hostRoutes
+	host = hostname(host)
+	for _, h := range hs {
+		if _, ok := h.match(host, nil); ok {
+			matched = append(matched, h)
+		}
+	}
+	return matched
+}
+
+// lookup looks up the handle for the method and path. The routes of the host
+// patterns matching the host are tried in turn before the routes without
+// host. The values of params are appended to buf, which may be nil, the values
+// of host params first. The path of the matched route is returned without the
+// host pattern it was registered for, which is returned separately. A
+// trailing slash redirect is recommended if any of the tried trees does so.
+func (hs hostList) lookup(host, method, path string, buf Params) (handle Handle, ps Params, pattern, route string, tsr bool) {
+	host = hostname(host)
+	for _, h := range hs {
+		root := h.trees[method]
+		if root == nil {
+			continue
+		}
+		hps, ok := h.match(host, buf)
+		if !ok {
+			continue
+		}
+		var hostTSR bool
+		handle, ps, route, hostTSR = root.getValue(path, hps)
+		tsr = tsr || hostTSR
+		if handle != nil {
+			return handle, ps, h.host, route, tsr
+		}
+	}
+	return nil, nil, "", "", tsr
+}
+
+// trees returns the trees of the method which may serve a request for the
+// host, in the order in which they are tried.
+func (hs hostList) trees(host, method string) []*node {
+	var roots []*node
+	for _, h := range hs.matching(host) {
+		if h.trees[method] != nil {
+			roots = append(roots, h.trees[method])
+		}
+	}
+	return roots
+}
+
+// add adds the route, whose path may begin with a host pattern, to a copy of
+// the list and returns the copy. The list itself is not modified. A pattern
+// with optional parts is added as each of the routes it stands for.
+func (hs hostList) add(r *Router, method, path string, handle Handle, meta interface{}, matchers []Matcher) (hostList, *RouteError) {
+	host, p := splitHost(path)
+	if host != "" {
+		if _, _, ok := parseHost(host); !ok {
+			return nil, &RouteError{
+				Method: method,
+				Path:   path,
+				Reason: "malformed host '" + host + "'",
+			}
+		}
+	}
+	routes, perr := expandPath(p)
+	if perr != nil {
+		return nil, &RouteError{
+			Method: method,
+			Path:   path,
+			Reason: perr.Error(),
+		}
+	}
+
+	for _, route := range routes {
+		var tree *node
+		if h := hs.find(host); h != nil {
+			tree = h.trees[method]
+		}
+		root, err := addRoute(tree, r, method, route.path, withDefaults(handle, route.defaults), meta, matchers)
+		if err != nil {
+			err.Path = path
+			if err.Conflict != "" {
+				err.Conflict = host + err.Conflict
+			}
+			return nil, err
+		}
+		hs = hs.withTree(host, method, root)
+	}
+	return hs, nil
+}
+
+// remove removes the route, whose path may begin with a host pattern, from a
+// copy of the list and returns the copy, like add.
+func (hs hostList) remove(r *Router, method, path string) (hostList, *RouteError) {
+	host, p := splitHost(path)
+	routes, perr := expandPath(p)
+	if perr != nil {
+		return nil, &RouteError{
+			Method: method,
+			Path:   path,
+			Reason: perr.Error(),
+		}
+	}
+
+	for _, route := range routes {
+		var tree *node
+		if h := hs.find(host); h != nil {
+			tree = h.trees[method]
+		}
+		if tree == nil || tree.findRoute(route.path) == nil {
+			return nil, &RouteError{
+				Method: method,
+				Path:   path,
+				Reason: "route is not registered",
+			}
+		}
+
+		// Rebuild the tree without the route, removing the nodes only it
+		// needed. The routes are added in the order of the tree, which keeps
+		// the order of params with constraints.
+		var root *node
+		var err *RouteError
+		tree.walk(func(n *node) {
+			if err != nil || n.fullPath == route.path {
+				return
+			}
+			root, err = addRoute(root, r, method, n.fullPath, n.handle, n.meta, nil)
+			if err == nil && n.variants != nil {
+				root.findRoute(n.fullPath).variants = n.variants
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		hs = hs.withTree(host, method, root)
+	}
+	return hs, nil
+}
+
+// withDefaults returns a handle which passes the default values of the params
+// omitted by an optional pattern on to the handle.
+func withDefaults(handle Handle, defaults Params) Handle {
+	if len(defaults) == 0 {
+		return handle
+	}
+	return func(w http.ResponseWriter, req *http.Request, ps Params) {
+		handle(w, req, append(ps, defaults...))
+	}
+}
+
+// withTree returns a copy of the list in which the tree of the method for the
+// host pattern is replaced. The tree is removed if root is nil.
+func (hs hostList) withTree(host, method string, root *node) hostList {
+	list := make(hostList, 0, len(hs)+1)
+	h := &hostRoutes{host: host, trees: make(map[string]*node)}
+	if old := hs.find(host); old != nil {
+		h.labels, h.wild = old.labels, old.wild
+		for m, tree := range old.trees {
+			h.trees[m] = tree
+		}
+	} else if host != "" {
+		h.labels, h.wild, _ = parseHost(host)
+	}
+	if root != nil {
+		h.trees[method] = root
+	} else {
+		delete(h.trees, method)
+	}
+
+	added := len(h.trees) == 0 // drop hosts without routes
+	for _, old := range hs {
+		if old.host == host {
+			if !added {
+				list = append(list, h)
+				added = true
+			}
+			continue
+		}
+		// exact hosts before wildcard hosts before no host
+		if !added && (old.host == "" || (old.wild && !h.wild && h.host != "")) {
+			list = append(list, h)
+			added = true
+		}
+		list = append(list, old)
+	}
+	if !added {
+		list = append(list, h)
+	}
+	return list
+}
@@ -0,0 +1,148 @@
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+
+package httprouter
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Matcher reports whether a request matches a route, in addition to its
+// method and path. Several handles can be registered for the same method and
+// path with different matchers, e.g. for different versions of an API.
+type Matcher func(req *http.Request) bool
+
+// Header returns a Matcher for requests which have the header with the given
+// value. If the value is empty, the header only has to be present.
+func Header(key, value string) Matcher {
+	key = http.CanonicalHeaderKey(key)
+	return func(req *http.Request) bool {
+		values, ok := req.Header[key]
+		if !ok {
+			return false
+		}
+		if value == "" {
+			return true
+		}
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Query returns a Matcher for requests which have the query parameter with
+// the given value. If the value is empty, the parameter only has to be
+// present.
+func Query(key, value string) Matcher {
+	return func(req *http.Request) bool {
+		values, ok := req.URL.Query()[key]
+		if !ok {
+			return false
+		}
+		if value == "" {
+			return true
+		}
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MediaType returns a Matcher for requests which accept the given media type,
+// e.g. application/vnd.acme.v2+json, according to their Accept header.
+// The media type must be listed explicitly and not with a quality of 0.
+// Media ranges like */* don't match, such requests are served by the handle
+// registered without matchers, if any.
+func MediaType(mediaType string) Matcher {
+	return func(req *http.Request) bool {
+		for _, accept := range req.Header["Accept"] {
+			for _, r := range strings.Split(accept, ",") {
+				if accepts(r, mediaType) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// accepts reports whether the element of an Accept header is the media type
+// with a quality above 0.
+func accepts(r, mediaType string) bool {
+	params := strings.Split(r, ";")
+	if !strings.EqualFold(strings.TrimSpace(params[0]), mediaType) {
+		return false
+	}
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if len(param) > 2 && (param[0] == 'q' || param[0] == 'Q') && param[1] == '=' {
+			q, err := strconv.ParseFloat(param[2:], 64)
+			return err == nil && q > 0
+		}
+	}
+	return true
+}
+
+// variant is one of several handles registered for a route.
+type variant struct {
+	matchers []Matcher
+	handle   Handle
+	meta     interface{}
+}
+
+// variants are the handles registered for a route with matchers. The handle
+// of the route's node is the dispatch method. Like the nodes, variants are
+// never modified once stored, they are replaced.
+type variants struct {
+	router *Router
+	list   []variant // the variant without matchers, if any, comes last
+}
+
+// with returns the variants with the given one added.
+func (vs *variants) with(v variant) *variants {
+	list := make([]variant, 0, len(vs.list)+1)
+	list = append(list, vs.list...)
+	if len(v.matchers) > 0 && len(list) > 0 && len(list[len(list)-1].matchers) == 0 {
+		// keep the default last
+		list = append(list[:len(list)-1], v, list[len(list)-1])
+	} else {
+		list = append(list, v)
+	}
+	return &variants{router: vs.router, list: list}
+}
+
+// hasDefault reports whether a variant without matchers is registered.
+func (vs *variants) hasDefault() bool {
+	return len(vs.list) > 0 && len(vs.list[len(vs.list)-1].matchers) == 0
+}
+
+// dispatch calls the handle of the first variant which matches the request.
+func (vs *variants) dispatch(w http.ResponseWriter, req *http.Request, ps Params) {
+walk:
+	for _, v := range vs.list {
+		for _, match := range v.matchers {
+			if !match(req) {
+				continue walk
+			}
+		}
+		v.handle(w, req, ps)
+		return
+	}
+
+	if vs.router.NotAcceptable != nil {
+		vs.router.NotAcceptable.ServeHTTP(w, req)
+	} else {
+		http.Error(w,
+			http.StatusText(http.StatusNotAcceptable),
+			http.StatusNotAcceptable,
+		)
+	}
+}
@@ -0,0 +1,151 @@
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+
+package httprouter
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"bitbucket.org/ardanlabs/cobalt/uuid"
+)
+
+// ErrMissingParam is the error of a ParamError for a param which is not part
+// of the matched route.
+var ErrMissingParam = errors.New("missing param")
+
+// ParamError is returned by the typed getters of Params if the param is
+// missing or its value can't be converted to the requested type. Both are
+// usually answered with 400 Bad Request.
+type ParamError struct {
+	Name  string
+	Value string
+	Type  string // the requested type, e.g. int64
+	Err   error  // ErrMissingParam or the error of the conversion
+}
+
+func (e *ParamError) Error() string {
+	if e.Err == ErrMissingParam {
+		return "param '" + e.Name + "' is missing"
+	}
+	return "param '" + e.Name + "': '" + e.Value + "' is not a valid " + e.Type
+}
+
+// Unwrap returns the underlying error.
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// Get returns the value of the first Param which key matches the given name
+// and reports whether there is one. Unlike ByName it tells a missing param
+// from an empty one. It only returns the params of the route, not the ones
+// the router adds, like MatchedRoutePathParam.
+func (ps Params) Get(name string) (string, bool) {
+	if internal(name) {
+		return "", false
+	}
+	for i := range ps {
+		if ps[i].Key == name {
+			return ps[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// Int64 returns the value of the named param as a base 10 integer.
+func (ps Params) Int64(name string) (int64, error) {
+	value, err := ps.lookup(name, "int64")
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, &ParamError{Name: name, Value: value, Type: "int64", Err: err}
+	}
+	return i, nil
+}
+
+// Uint64 returns the value of the named param as a base 10 unsigned integer.
+func (ps Params) Uint64(name string) (uint64, error) {
+	value, err := ps.lookup(name, "uint64")
+	if err != nil {
+		return 0, err
+	}
+	u, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, &ParamError{Name: name, Value: value, Type: "uint64", Err: err}
+	}
+	return u, nil
+}
+
+// Bool returns the value of the named param as a boolean, see strconv.ParseBool
+// for the accepted values.
+func (ps Params) Bool(name string) (bool, error) {
+	value, err := ps.lookup(name, "bool")
+	if err != nil {
+		return false, err
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, &ParamError{Name: name, Value: value, Type: "bool", Err: err}
+	}
+	return b, nil
+}
+
+// UUID returns the value of the named param as a UUID in one of the hex forms
+// accepted by uuid.ParseHex, e.g. 6ba7b814-9dad-11d1-80b4-00c04fd430c8.
+func (ps Params) UUID(name string) (uuid.UUID, error) {
+	value, err := ps.lookup(name, "uuid")
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	u, err := uuid.ParseHex(value)
+	if err != nil {
+		return uuid.UUID{}, &ParamError{Name: name, Value: value, Type: "uuid", Err: err}
+	}
+	return *u, nil
+}
+
+// Time returns the value of the named param as a time in the given layout,
+// see time.Parse.
+func (ps Params) Time(name, layout string) (time.Time, error) {
+	value, err := ps.lookup(name, "time")
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, &ParamError{Name: name, Value: value, Type: "time in the layout " + layout, Err: err}
+	}
+	return t, nil
+}
+
+// Map returns the params of the route as a map from key to value, e.g. for
+// logging. Like ByName, the first value of a key wins. The params the router
+// adds, like OriginalPathParam, are left out.
+func (ps Params) Map() map[string]string {
+	m := make(map[string]string, len(ps))
+	for i := len(ps) - 1; i >= 0; i-- {
+		if !internal(ps[i].Key) {
+			m[ps[i].Key] = ps[i].Value
+		}
+	}
+	return m
+}
+
+// internal reports whether the key is the one of a param the router adds,
+// like MatchedRoutePathParam. They start with '$', which the names of
+// wildcards can't.
+func internal(key string) bool {
+	return len(key) > 0 && key[0] == '$'
+}
+
+// lookup returns the value of the named param or a ParamError if it is missing.
+func (ps Params) lookup(name, typ string) (string, error) {
+	value, ok := ps.Get(name)
+	if !ok {
+		return "", &ParamError{Name: name, Type: typ, Err: ErrMissingParam}
+	}
+	return value, nil
+}
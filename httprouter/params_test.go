@@ -0,0 +1,91 @@
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+
+package httprouter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParamsGet(t *testing.T) {
+	ps := Params{{"id", "42"}, {"empty", ""}, {"id", "43"}, {MatchedRoutePathParam, "/users/:id"}, {OriginalPathParam, "/Users/42"}}
+
+	if value, ok := ps.Get("id"); !ok || value != "42" {
+		t.Errorf("Get(id) = %q, %t, want 42, true", value, ok)
+	}
+	if value, ok := ps.Get("empty"); !ok || value != "" {
+		t.Errorf("Get(empty) = %q, %t, want empty, true", value, ok)
+	}
+	if value, ok := ps.Get("missing"); ok || value != "" {
+		t.Errorf("Get(missing) = %q, %t, want empty, false", value, ok)
+	}
+
+	if value, ok := ps.Get(OriginalPathParam); ok {
+		t.Errorf("Get(%s) = %q, %t, want empty, false", OriginalPathParam, value, ok)
+	}
+	if ps.OriginalPath() != "/Users/42" || ps.MatchedRoutePath() != "/users/:id" {
+		t.Errorf("expected the params of the router to be kept instead got %v", ps)
+	}
+
+	want := map[string]string{"id": "42", "empty": ""}
+	if m := ps.Map(); !reflect.DeepEqual(m, want) {
+		t.Errorf("Map() = %v, want %v", m, want)
+	}
+}
+
+func TestParamsTyped(t *testing.T) {
+	ps := Params{
+		{"int", "-42"},
+		{"uint", "42"},
+		{"bool", "true"},
+		{"uuid", "6ba7b814-9dad-11d1-80b4-00c04fd430c8"},
+		{"date", "2024-05-01"},
+		{"bad", "x"},
+	}
+
+	if i, err := ps.Int64("int"); err != nil || i != -42 {
+		t.Errorf("Int64(int) = %d, %v", i, err)
+	}
+	if u, err := ps.Uint64("uint"); err != nil || u != 42 {
+		t.Errorf("Uint64(uint) = %d, %v", u, err)
+	}
+	if b, err := ps.Bool("bool"); err != nil || !b {
+		t.Errorf("Bool(bool) = %t, %v", b, err)
+	}
+	if u, err := ps.UUID("uuid"); err != nil || u.String() != "6ba7b814-9dad-11d1-80b4-00c04fd430c8" {
+		t.Errorf("UUID(uuid) = %s, %v", u.String(), err)
+	}
+	if d, err := ps.Time("date", "2006-01-02"); err != nil || !d.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Time(date) = %s, %v", d, err)
+	}
+
+	errs := []error{}
+	_, err := ps.Int64("bad")
+	errs = append(errs, err)
+	_, err = ps.Uint64("int")
+	errs = append(errs, err)
+	_, err = ps.Bool("bad")
+	errs = append(errs, err)
+	_, err = ps.UUID("bad")
+	errs = append(errs, err)
+	_, err = ps.Time("bad", time.RFC3339)
+	errs = append(errs, err)
+	for _, err := range errs {
+		var perr *ParamError
+		if !errors.As(err, &perr) || errors.Is(err, ErrMissingParam) {
+			t.Errorf("expected a conversion error, got %v", err)
+		}
+	}
+
+	_, err = ps.Int64("missing")
+	if !errors.Is(err, ErrMissingParam) || err.Error() != "param 'missing' is missing" {
+		t.Errorf("expected a missing param error, got %v", err)
+	}
+	_, err = ps.Int64("bad")
+	if want := "param 'bad': 'x' is not a valid int64"; err == nil || err.Error() != want {
+		t.Errorf("wrong error message:\n got %v\nwant %s", err, want)
+	}
+}
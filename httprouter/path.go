@@ -5,6 +5,12 @@
 
 package httprouter
 
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
 // CleanPath is the URL version of path.Clean, it returns a canonical URL path
 // for p, eliminating . and .. elements.
 //
@@ -121,3 +127,290 @@ func bufApp(buf *[]byte, s string, w int, c byte) {
 	}
 	(*buf)[w] = c
 }
+
+// BuildPath fills the values of the given parameters into the path of a
+// route, e.g. /user/:name with name=gopher becomes /user/gopher. It is the
+// reverse of the lookup and allows to build links from routes.
+// Values are escaped. The value of a catch-all parameter may contain slashes,
+// with or without a leading slash. If the path begins with a host, its params
+// are filled in as well and the host is part of the result, e.g.
+// :tenant.example.com/users with tenant=acme becomes acme.example.com/users.
+// It returns an error if a parameter of the path has no value, if a value
+// doesn't match the constraint of its parameter or if a value is given for a
+// parameter which is not part of the path.
+func BuildPath(path string, ps Params) (string, error) {
+	host, p := splitHost(path)
+	routes, err := expandPath(p)
+	if err != nil {
+		return "", errors.New(err.Error() + " in path '" + path + "'")
+	}
+	if len(routes) > 1 {
+		// build the longest route for which all values are given
+		route := routes[len(routes)-1]
+	find:
+		for _, r := range routes {
+			for _, name := range paramNames(r.path) {
+				if value, _ := ps.Get(name); value == "" {
+					continue find
+				}
+			}
+			route = r
+			break
+		}
+		return BuildPath(host+route.path, ps)
+	}
+
+	buf := make([]byte, 0, len(path))
+	names := paramNames(p)
+
+	if host != "" {
+		labels, _, ok := parseHost(host)
+		if !ok {
+			return "", errors.New("malformed host '" + host + "' in path '" + path + "'")
+		}
+		for i, label := range labels {
+			if i > 0 {
+				buf = append(buf, '.')
+			}
+			switch {
+			case label == "*":
+				return "", errors.New("can't fill in the wildcard of host '" + host + "'")
+			case label[0] == ':':
+				value, found := ps.Get(label[1:])
+				if !found || value == "" {
+					return "", errors.New("missing value for parameter '" + label[1:] + "'")
+				}
+				if strings.IndexFunc(value, notHostLabel) >= 0 {
+					return "", errors.New("value '" + value + "' of parameter '" + label[1:] +
+						"' is not a valid host label")
+				}
+				buf = append(buf, value...)
+			default:
+				buf = append(buf, label...)
+			}
+		}
+		names = append(hostParamNames(labels), names...)
+	}
+
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c != ':' && c != '*' {
+			buf = append(buf, c)
+			continue
+		}
+
+		end := i + wildcardEnd(p[i:])
+		key, expr, ok := parseWildcard(p[i:end])
+		if !ok || key == "" {
+			return "", errors.New("malformed wildcard '" + p[i:end] + "' in path '" + path + "'")
+		}
+		i = end - 1
+
+		// a catch-all parameter may be empty, a named parameter not
+		value, found := ps.Get(key)
+		if !found || (c == ':' && value == "") {
+			return "", errors.New("missing value for parameter '" + key + "'")
+		}
+
+		if c == '*' {
+			// the leading '/' of the value is the one in front of the catch-all
+			value = strings.TrimPrefix(value, "/")
+			for j, segment := range strings.Split(value, "/") {
+				if j > 0 {
+					buf = append(buf, '/')
+				}
+				buf = append(buf, url.PathEscape(segment)...)
+			}
+			continue
+		}
+
+		if expr != "" {
+			check, err := newConstraint(expr)
+			if err != nil {
+				return "", errors.New("invalid constraint '" + expr + "' in path '" + path + "'")
+			}
+			if !check.match(value) {
+				return "", errors.New("value '" + value + "' of parameter '" + key +
+					"' doesn't match the constraint '" + expr + "'")
+			}
+		}
+		buf = append(buf, url.PathEscape(value)...)
+	}
+
+	for _, p := range ps {
+		if !contains(names, p.Key) {
+			return "", errors.New("unknown parameter '" + p.Key + "' for path '" + path + "'")
+		}
+	}
+
+	return string(buf), nil
+}
+
+// expansion is one of the routes an optional pattern stands for, with the
+// default values of the params it omits.
+type expansion struct {
+	path     string
+	defaults Params
+}
+
+// expandPath returns the routes an optional pattern stands for, the longest
+// first. Parts of a pattern in parentheses beginning with a slash, like the
+// (/:month) of /archive/:year(/:month), are optional. A param followed by '?'
+// like /items/:page? is short for (/:page). A param in an optional part can
+// have a default value, e.g. (/:month=01) or /items/:page?=1, which is passed
+// to the handle if the part is omitted.
+func expandPath(path string) ([]expansion, error) {
+	if !strings.Contains(path, "(/") && !strings.Contains(path, "?") {
+		return []expansion{{path: path}}, nil
+	}
+
+	path, err := optionalParams(path)
+	if err != nil {
+		return nil, err
+	}
+	x := expander{path: path}
+	routes, _, err := x.seq(0)
+	if err != nil {
+		return nil, err
+	}
+	for i := range routes {
+		if routes[i].path == "" {
+			routes[i].path = "/"
+		}
+	}
+	return routes, nil
+}
+
+// optionalParams rewrites the optional params of a pattern like /:page? into
+// optional parts like (/:page).
+func optionalParams(path string) (string, error) {
+	buf := make([]byte, 0, len(path)+8)
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c != ':' && c != '*' {
+			buf = append(buf, c)
+			continue
+		}
+
+		end := i + wildcardEnd(path[i:])
+		if end == len(path) || path[end] != '?' {
+			buf = append(buf, path[i:end]...)
+			i = end - 1
+			continue
+		}
+
+		// the default value, if any, ends with the segment
+		stop := end + 1
+		for stop < len(path) && path[stop] != '/' && path[stop] != '(' && path[stop] != ')' {
+			stop++
+		}
+		if c != ':' || i == 0 || path[i-1] != '/' || (stop > end+1 && path[end+1] != '=') {
+			return "", errors.New("optional param '" + path[i:end+1] + "' must fill a whole path segment")
+		}
+
+		// replace the '/' in front of the param
+		buf = append(buf[:len(buf)-1], "(/"...)
+		buf = append(buf, path[i:end]...)
+		buf = append(buf, path[end+1:stop]...)
+		buf = append(buf, ')')
+		i = stop - 1
+	}
+	return string(buf), nil
+}
+
+// expander expands the optional parts of a pattern.
+type expander struct {
+	path string
+	pos  int
+}
+
+// seq expands the pattern from the current position up to the end of the
+// optional part at the given depth, or the end of the pattern at depth 0. It
+// returns the default values of all params in it as well.
+func (x *expander) seq(depth int) (routes []expansion, defaults Params, err error) {
+	routes = []expansion{{}}
+	for x.pos < len(x.path) {
+		var alts []expansion
+		switch c := x.path[x.pos]; {
+		case c == '(' && x.pos+1 < len(x.path) && x.path[x.pos+1] == '/':
+			x.pos++
+			inner, innerDefaults, err := x.seq(depth + 1)
+			if err != nil {
+				return nil, nil, err
+			}
+			// the part is either there or not
+			alts = append(inner, expansion{defaults: innerDefaults})
+			defaults = append(defaults, innerDefaults...)
+
+		case c == ')' && depth > 0:
+			x.pos++
+			return routes, defaults, nil
+
+		case c == ':' || c == '*':
+			end := x.pos + wildcardEnd(x.path[x.pos:])
+			wildcard := x.path[x.pos:end]
+			x.pos = end
+			if depth > 0 && end < len(x.path) && x.path[end] == '=' {
+				stop := end + 1
+				for stop < len(x.path) && !strings.ContainsRune("/()", rune(x.path[stop])) {
+					stop++
+				}
+				value := x.path[end+1 : stop]
+				x.pos = stop
+
+				key, expr, ok := parseWildcard(wildcard)
+				if value == "" {
+					return nil, nil, errors.New("empty default value for param '" + key + "'")
+				}
+				if ok && expr != "" {
+					if check, err := newConstraint(expr); err == nil && !check.match(value) {
+						return nil, nil, errors.New("default value '" + value + "' of param '" + key +
+							"' doesn't match the constraint '" + expr + "'")
+					}
+				}
+				defaults = append(defaults, Param{key, value})
+			}
+			alts = []expansion{{path: wildcard}}
+
+		default:
+			// static text up to the next wildcard or optional part
+			end := x.pos + 1
+			for end < len(x.path) && x.path[end] != ':' && x.path[end] != '*' &&
+				x.path[end] != '(' && (x.path[end] != ')' || depth == 0) {
+				end++
+			}
+			alts = []expansion{{path: x.path[x.pos:end]}}
+			x.pos = end
+		}
+
+		combined := make([]expansion, 0, len(routes)*len(alts))
+		for _, r := range routes {
+			for _, alt := range alts {
+				combined = append(combined, expansion{
+					path:     r.path + alt.path,
+					defaults: append(r.defaults[:len(r.defaults):len(r.defaults)], alt.defaults...),
+				})
+			}
+		}
+		routes = combined
+	}
+
+	if depth > 0 {
+		return nil, nil, errors.New("unclosed optional part")
+	}
+	return routes, defaults, nil
+}
+
+// notHostLabel reports whether r is not allowed in a host label.
+func notHostLabel(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-')
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
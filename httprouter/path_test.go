@@ -6,6 +6,7 @@
 package httprouter
 
 import (
+	"reflect"
 	"runtime"
 	"testing"
 )
@@ -90,3 +91,98 @@ func TestPathCleanMallocs(t *testing.T) {
 		}
 	}
 }
+
+var buildTests = []struct {
+	path   string
+	ps     Params
+	result string
+	err    bool
+}{
+	{"/", nil, "/", false},
+	{"/user/:name", Params{{"name", "gopher"}}, "/user/gopher", false},
+	{"/user/:name", Params{{"name", "go pher/x"}}, "/user/go%20pher%2Fx", false},
+	{"/user/:id{int}/posts", Params{{"id", "42"}}, "/user/42/posts", false},
+	{"/src/*filepath", Params{{"filepath", "/a b/c.go"}}, "/src/a%20b/c.go", false},
+	{"/src/*filepath", Params{{"filepath", "a/c.go"}}, "/src/a/c.go", false},
+	{"/src/*filepath", Params{{"filepath", ""}}, "/src/", false},
+	{"/files/:name.:ext", Params{{"name", "app"}, {"ext", "tar.gz"}}, "/files/app.tar.gz", false},
+	{"/v:version/users", Params{{"version", "2"}}, "/v2/users", false},
+	{"api.example.com/users", nil, "api.example.com/users", false},
+	{"/items/:page?=1", nil, "/items", false},
+	{"/items/:page?=1", Params{{"page", "3"}}, "/items/3", false},
+	{"/archive(/:year(/:month))", Params{{"year", "2024"}}, "/archive/2024", false},
+	{"/archive(/:year(/:month))", Params{{"year", "2024"}, {"month", "05"}}, "/archive/2024/05", false},
+	{":tenant.example.com/users/:id", Params{{"tenant", "acme"}, {"id", "1"}}, "acme.example.com/users/1", false},
+
+	// errors
+	{"/user/:name", nil, "", true},
+	{"/user/:name", Params{{"name", ""}}, "", true},
+	{"/user/:name", Params{{"name", "gopher"}, {"id", "1"}}, "", true},
+	{"/user/:id{int}", Params{{"id", "gopher"}}, "", true},
+	{"/src/*filepath", nil, "", true},
+	{":tenant.example.com/", nil, "", true},
+	{":tenant.example.com/", Params{{"tenant", "a.b"}}, "", true},
+	{"*.example.com/", nil, "", true},
+	{"/archive(/:year(/:month))", Params{{"month", "05"}}, "", true},
+}
+
+func TestBuildPath(t *testing.T) {
+	for _, test := range buildTests {
+		s, err := BuildPath(test.path, test.ps)
+		if test.err {
+			if err == nil {
+				t.Errorf("BuildPath(%q, %v) = %q, want error", test.path, test.ps, s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("BuildPath(%q, %v): unexpected error: %v", test.path, test.ps, err)
+		} else if s != test.result {
+			t.Errorf("BuildPath(%q, %v) = %q, want %q", test.path, test.ps, s, test.result)
+		}
+	}
+}
+
+var expandTests = []struct {
+	path   string
+	routes []expansion
+	err    bool
+}{
+	{"/items", []expansion{{"/items", nil}}, false},
+	{"/items/:page?", []expansion{{"/items/:page", nil}, {"/items", nil}}, false},
+	{"/items/:page{int}?=1", []expansion{{"/items/:page{int}", nil}, {"/items", Params{{"page", "1"}}}}, false},
+	{"/:page?", []expansion{{"/:page", nil}, {"/", nil}}, false},
+	{"/archive(/:year=2024(/:month=01))", []expansion{
+		{"/archive/:year/:month", nil},
+		{"/archive/:year", Params{{"month", "01"}}},
+		{"/archive", Params{{"year", "2024"}, {"month", "01"}}},
+	}, false},
+	{"/a(/:x)(/:y)", []expansion{{"/a/:x/:y", nil}, {"/a/:x", nil}, {"/a/:y", nil}, {"/a", nil}}, false},
+	{"/wiki/Go_(language)", []expansion{{"/wiki/Go_(language)", nil}}, false},
+	{"/items/:page=1", []expansion{{"/items/:page=1", nil}}, false},
+	{"/users/:id{(a|b)}(/:tab)", []expansion{{"/users/:id{(a|b)}/:tab", nil}, {"/users/:id{(a|b)}", nil}}, false},
+
+	// errors
+	{"/items/x:page?", nil, true},
+	{"/items/:page?x", nil, true},
+	{"/archive(/:year", nil, true},
+	{"/archive(/:year=)", nil, true},
+	{"/archive(/:year{int}=now)", nil, true},
+}
+
+func TestExpandPath(t *testing.T) {
+	for _, test := range expandTests {
+		routes, err := expandPath(test.path)
+		if test.err {
+			if err == nil {
+				t.Errorf("expandPath(%q) = %v, want error", test.path, routes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expandPath(%q): unexpected error: %v", test.path, err)
+		} else if !reflect.DeepEqual(routes, test.routes) {
+			t.Errorf("expandPath(%q) = %v, want %v", test.path, routes, test.routes)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+// Use of this source code is governed by a BSD-style license that can be found
+// in the LICENSE file.
+
+//go:build race
+// +build race
+
+package httprouter
+
+// sync.Pool drops items at random with the race detector, so the counted
+// allocations are not meaningful then.
+const raceEnabled = true
@@ -53,6 +53,23 @@
 //   /blog/go/                           no match
 //   /blog/go/request-routers/comments   no match
 //
+// A named parameter can be restricted by a constraint in braces. It is either
+// one of the predefined constraints int, uint, alpha, alnum, hex and uuid, or a
+// regular expression which must match the whole value:
+//  Path: /user/:id{int}
+//
+//  Requests:
+//   /user/42                            match: id="42"
+//   /user/gopher                        no match
+//
+// Static path segments and parameters may share the same position in the
+// path, e.g. /user/new, /user/:id{int} and /user/:name. A request is matched
+// against the static segments first, then the constrained parameters in the
+// order in which the routes were registered, then the unconstrained parameter
+// and last the catch-all parameter. If a match leads nowhere further down the
+// path, the next candidate is tried. Only one unconstrained parameter and one
+// catch-all parameter can be registered at the same position.
+//
 // Catch-all parameters match anything until the path end, including the
 // directory index (the '/' before the catch-all). Since they match anything
 // until the end, catch-all parameters must always be the final path element.
@@ -64,6 +81,25 @@
 //   /files/templates/article.html       match: filepath="/templates/article.html"
 //   /files                              no match, but the router would redirect
 //
+// A path can begin with a host, which restricts the route to requests for that
+// host, e.g. api.example.com/users. Each label of the host is either static, a
+// named parameter or the wildcard *, which matches any single label:
+//  Path: :tenant.example.com/users
+//
+//  Requests:
+//   acme.example.com/users              match: tenant="acme"
+//   example.com/users                   no match
+//   a.b.example.com/users               no match
+//
+// Exact hosts are tried before hosts with parameters or wildcards, and those in
+// the order in which the hosts were first used. The routes of each matching
+// host are tried in turn before the routes without a host, which serve the
+// requests for all hosts. The port of the request host is ignored.
+//
+// Several handles can be registered for the same method and path with
+// RegisterMatch, each with matchers on the request, e.g. on its headers or on
+// the media types it accepts. See RegisterMatch.
+//
 // The value of parameters is saved as a slice of the Param struct, consisting
 // each of a key and a value. The slice is passed to the Handle func as a third
 // parameter.
@@ -78,11 +114,20 @@ package httprouter
 
 import (
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // Handle is a function that can be registered to a route to handle HTTP
 // requests. Like http.HandlerFunc, but has a third parameter for the values of
 // wildcards (variables).
+// The Params passed to a Handle by the Router are reused for other requests
+// once the Handle returns, so they must not be kept after that, e.g. by a
+// goroutine. Values which are needed longer must be copied.
 type Handle func(http.ResponseWriter, *http.Request, Params)
 
 // Param is a single URL parameter, consisting of a key and a value.
@@ -93,6 +138,7 @@ type Param struct {
 
 // Params is a Param-slice, as returned by the router.
 // The slice is ordered, the first URL parameter is also the first slice value.
+// The parameters of the host come before those of the path.
 // It is therefore safe to read values by the index.
 type Params []Param
 
@@ -107,16 +153,102 @@ func (ps Params) ByName(name string) string {
 	return ""
 }
 
+// MatchedRoutePathParam is the Param name under which the path of the matched
+// route is stored, if Router.SaveMatchedRoutePath is set.
+var MatchedRoutePathParam = "$matchedRoutePath"
+
+// OriginalPathParam is the Param name under which the path of a request is
+// stored if it was corrected before it was served, see Router.ServeFixedPath.
+var OriginalPathParam = "$originalPath"
+
+// OriginalPath retrieves the path of the request before it was corrected,
+// e.g. /USERS/ for a request served by the route /users. The corrected path
+// is the path of the request URL. It returns an empty string if the path
+// wasn't corrected.
+func (ps Params) OriginalPath() string {
+	return ps.ByName(OriginalPathParam)
+}
+
+// MatchedRoutePath retrieves the path of the matched route, e.g. /user/:name.
+// Router.SaveMatchedRoutePath must be enabled, otherwise this function always
+// returns an empty string.
+func (ps Params) MatchedRoutePath() string {
+	return ps.ByName(MatchedRoutePathParam)
+}
+
+// Route is a route as registered with the router.
+type Route struct {
+	Method string
+	Path   string
+
+	// Names of the parameters of the path, in order. Set by Walk.
+	Params []string
+
+	// Metadata attached to the route with RegisterMeta or RegisterMatch.
+	Meta interface{}
+
+	// Matchers of the route, see RegisterMatch.
+	Matchers []Matcher
+}
+
+// WalkFunc is the type of the function called by Walk for each route.
+type WalkFunc func(route Route, handle Handle) error
+
+// RouteError describes why a route can not be registered.
+type RouteError struct {
+	Method string
+	Path   string
+
+	// Path of the registered route the route conflicts with, if any.
+	Conflict string
+
+	Reason string
+}
+
+func (e *RouteError) Error() string {
+	msg := e.Path + ": " + e.Reason
+	if e.Method != "" {
+		msg = e.Method + " " + msg
+	}
+	if e.Conflict != "" {
+		msg += " (registered route '" + e.Conflict + "')"
+	}
+	return msg
+}
+
+// RouteErrors is the list of errors returned by Router.Validate.
+type RouteErrors []*RouteError
+
+func (e RouteErrors) Error() string {
+	msg := "invalid routes:"
+	for _, err := range e {
+		msg += "\n\t" + err.Error()
+	}
+	return msg
+}
+
 // Router is a http.Handler which can be used to dispatch requests to different
 // handler functions via configurable routes
 type Router struct {
-	trees map[string]*node
+	// hosts holds the hostList with the trees by method for each host.
+	// Neither the list nor the trees are modified once stored, routes are
+	// added to and removed from copies which replace them. Requests are
+	// therefore served without locking, each from a consistent view of the
+	// routes.
+	hosts atomic.Value
+
+	// mu serializes changes of the routes.
+	mu sync.Mutex
+
+	// paramsPool holds the *Params reused for the requests, so that serving
+	// a request with params allocates nothing.
+	paramsPool sync.Pool
 
 	// Enables automatic redirection if the current route can't be matched but a
 	// handler for the path with (without) the trailing slash exists.
 	// For example if /foo/ is requested but a route only exists for /foo, the
 	// client is redirected to /foo with http status code 301 for GET requests
-	// and 307 for all other request methods.
+	// and 307 for all other request methods, see RedirectCode.
 	RedirectTrailingSlash bool
 
 	// If enabled, the router tries to fix the current request path, if no
@@ -125,19 +257,68 @@ type Router struct {
 	// Afterwards the router does a case-insensitive lookup of the cleaned path.
 	// If a handle can be found for this route, the router makes a redirection
 	// to the corrected path with status code 301 for GET requests and 307 for
-	// all other request methods.
+	// all other request methods, see RedirectCode.
 	// For example /FOO and /..//Foo could be redirected to /foo.
 	// RedirectTrailingSlash is independent of this option.
 	RedirectFixedPath bool
 
+	// Status codes of the redirects made by RedirectTrailingSlash and
+	// RedirectFixedPath, for GET requests and for requests with all other
+	// methods. The latter must preserve the method, i.e. be 307 or 308.
+	// If they are not set, 301 and 307 are used.
+	RedirectCode       int
+	RedirectMethodCode int
+
+	// Configurable function which writes the redirects made by
+	// RedirectTrailingSlash and RedirectFixedPath.
+	// If it is not set, the Location header and the status code are written,
+	// without a body.
+	Redirect func(w http.ResponseWriter, req *http.Request, url string, code int)
+
 	// If enabled, the router checks if another method is allowed for the
 	// current route, if the current request can not be routed.
 	// If this is the case, the request is answered with 'Method Not Allowed'
-	// and HTTP status code 405.
+	// and HTTP status code 405, and the allowed methods are listed in the
+	// Allow header.
 	// If no other Method is allowed, the request is delegated to the NotFound
 	// handler.
 	HandleMethodNotAllowed bool
 
+	// If enabled, the router automatically replies to OPTIONS requests with
+	// the allowed methods in the Allow header.
+	// Custom OPTIONS handles take priority over automatic replies.
+	HandleOPTIONS bool
+
+	// If enabled, HEAD requests for which no HEAD handle is registered are
+	// served by the GET handle of the path. The body written by the handle is
+	// discarded, while the headers are kept. Unless the handle sets them, the
+	// Content-Length and Content-Type headers are derived from the discarded
+	// body like for a GET request, so the header is only written when the
+	// handle returns.
+	HandleHEAD bool
+
+	// If enabled, requests which would be redirected by RedirectTrailingSlash
+	// or RedirectFixedPath are served by the handle of the corrected path
+	// instead, which saves the round trip and works for clients which don't
+	// follow redirects. The path of the request URL is set to the corrected
+	// path and the original path is added to the Params, see
+	// Params.OriginalPath.
+	ServeFixedPath bool
+
+	// If enabled, the escaped path of a request, as returned by
+	// URL.EscapedPath, is matched instead of URL.Path, which is unescaped.
+	// An encoded slash like in /files/a%2Fb then doesn't separate segments and
+	// the values of params are unescaped after matching, e.g. the param of
+	// /files/:key becomes a/b. Static parts of routes must be registered in
+	// their escaped form in this mode, e.g. /a%20b.
+	UseRawPath bool
+
+	// If enabled, the path of the matched route, e.g. /user/:name, is added to
+	// the Params passed to the handle as the last Param, which can be
+	// retrieved with Params.MatchedRoutePath. It allows to label logs and
+	// metrics by route instead of by request path.
+	SaveMatchedRoutePath bool
+
 	// Configurable http.Handler which is called when no matching route is
 	// found. If it is not set, http.NotFound is used.
 	NotFound http.Handler
@@ -147,6 +328,18 @@ type Router struct {
 	// If it is not set, http.Error with http.StatusMethodNotAllowed is used.
 	MethodNotAllowed http.Handler
 
+	// Configurable http.Handler which is called on automatic OPTIONS
+	// replies, e.g. to set CORS headers. It is only called if HandleOPTIONS
+	// is true and no OPTIONS handle is registered for the path.
+	// The Allow header is set before the handler is called.
+	GlobalOPTIONS http.Handler
+
+	// Configurable http.Handler which is called when a route has handles
+	// registered with matchers, but none matches the request and there is
+	// no handle without matchers.
+	// If it is not set, http.Error with http.StatusNotAcceptable is used.
+	NotAcceptable http.Handler
+
 	// Function to handle panics recovered from http handlers.
 	// It should be used to generate a error page and return the http error code
 	// 500 (Internal Server Error).
@@ -165,6 +358,7 @@ func New() *Router {
 		RedirectTrailingSlash:  true,
 		RedirectFixedPath:      true,
 		HandleMethodNotAllowed: true,
+		HandleOPTIONS:          true,
 	}
 }
 
@@ -204,6 +398,7 @@ func (r *Router) DELETE(path string, handle Handle) {
 }
 
 // Handle registers a new request handle with the given path and method.
+// It panics if the route can not be registered, see Register.
 //
 // For GET, POST, PUT, PATCH and DELETE requests the respective shortcut
 // functions can be used.
@@ -212,21 +407,210 @@ func (r *Router) DELETE(path string, handle Handle) {
 // frequently used, non-standardized or custom methods (e.g. for internal
 // communication with a proxy).
 func (r *Router) Handle(method, path string, handle Handle) {
-	if path[0] != '/' {
-		panic("path must begin with '/' in path '" + path + "'")
+	if err := r.Register(method, path, handle); err != nil {
+		panic(err.Error())
 	}
+}
 
-	if r.trees == nil {
-		r.trees = make(map[string]*node)
+// Register registers a new request handle with the given path and method.
+// Unlike Handle it returns a *RouteError if the path is malformed or conflicts
+// with a registered route. The router is left unchanged in this case.
+func (r *Router) Register(method, path string, handle Handle) error {
+	return r.RegisterMeta(method, path, handle, nil)
+}
+
+// RegisterMeta is like Register, but attaches arbitrary metadata to the route,
+// which is reported by Walk.
+//
+// Routes can be registered while the router serves requests. Requests which
+// are served while the route is registered are routed either with or without
+// it.
+func (r *Router) RegisterMeta(method, path string, handle Handle, meta interface{}) error {
+	return r.RegisterMatch(method, path, handle, meta)
+}
+
+// RegisterMatch is like RegisterMeta, but the handle only serves requests
+// which match all of the given matchers, e.g. requests accepting a media type.
+// Several handles can be registered for the same method and path this way.
+// They are tried in the order of registration. A handle registered for the
+// path without matchers serves the requests none of the others matches. If
+// there is none, the request is answered with 406 Not Acceptable, see
+// NotAcceptable.
+func (r *Router) RegisterMatch(method, path string, handle Handle, meta interface{}, matchers ...Matcher) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hosts, err := r.loadHosts().add(r, method, path, handle, meta, matchers)
+	if err != nil {
+		return err
+	}
+	r.hosts.Store(hosts)
+	return nil
+}
+
+// Remove removes the route registered with the given method and path, the
+// path must be the same as at registration. It returns a *RouteError if no
+// such route is registered.
+//
+// Like registering, removing is safe while the router serves requests.
+// Requests which are served while the route is removed are routed either with
+// or without it.
+func (r *Router) Remove(method, path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hosts, err := r.loadHosts().remove(r, method, path)
+	if err != nil {
+		return err
+	}
+	r.hosts.Store(hosts)
+	return nil
+}
+
+// loadHosts returns the current routes by host, which must not be modified.
+func (r *Router) loadHosts() hostList {
+	hosts, _ := r.hosts.Load().(hostList)
+	return hosts
+}
+
+// Walk calls fn for each registered route, ordered by method and path.
+// A route with handles registered with matchers is reported once per handle.
+// If fn returns an error, Walk stops and returns it.
+func (r *Router) Walk(fn WalkFunc) error {
+	var routes byPath
+	for _, h := range r.loadHosts() {
+		hostParams := hostParamNames(h.labels)
+		for method, tree := range h.trees {
+			tree.walk(func(n *node) {
+				route := Route{
+					Method: method,
+					Path:   h.host + n.fullPath,
+					Params: append(hostParams[:len(hostParams):len(hostParams)], paramNames(n.fullPath)...),
+					Meta:   n.meta,
+				}
+				if n.variants == nil {
+					routes = append(routes, walkRoute{route, n.handle})
+					return
+				}
+				for _, v := range n.variants.list {
+					route.Meta, route.Matchers = v.meta, v.matchers
+					routes = append(routes, walkRoute{route, v.handle})
+				}
+			})
+		}
+	}
+	sort.Stable(routes)
+
+	for _, route := range routes {
+		if err := fn(route.Route, route.handle); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// walkRoute is a route with its handle, as collected by Walk.
+type walkRoute struct {
+	Route
+	handle Handle
+}
 
-	root := r.trees[method]
-	if root == nil {
-		root = new(node)
-		r.trees[method] = root
+// byPath sorts routes by method and path.
+type byPath []walkRoute
+
+func (s byPath) Len() int { return len(s) }
+func (s byPath) Less(i, j int) bool {
+	if s[i].Method != s[j].Method {
+		return s[i].Method < s[j].Method
+	}
+	return s[i].Path < s[j].Path
+}
+func (s byPath) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// Validate checks whether the given routes can be registered, both with the
+// routes already registered and with each other. Nothing is registered.
+// All problems found are returned as RouteErrors.
+// It allows to check a route table, e.g. from a configuration, before any
+// route is registered.
+func (r *Router) Validate(routes []Route) error {
+	hosts := r.loadHosts()
+
+	var errs RouteErrors
+	for _, route := range routes {
+		added, err := hosts.add(r, route.Method, route.Path, validateHandle, nil, route.Matchers)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		hosts = added
 	}
 
-	root.addRoute(path, handle)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateHandle is registered by Validate, duplicates are only detected for
+// non-nil handles.
+func validateHandle(http.ResponseWriter, *http.Request, Params) {}
+
+// addRoute adds the route with its metadata to a copy of the given tree, which
+// may be nil, and returns the copy. The given tree is not modified.
+// If matchers are given or the route has handles with matchers already, the
+// handle is added as a variant of the route, see Router.RegisterMatch.
+func addRoute(tree *node, r *Router, method, path string, handle Handle, meta interface{}, matchers []Matcher) (*node, *RouteError) {
+	if len(path) == 0 || path[0] != '/' {
+		return nil, &RouteError{
+			Method: method,
+			Path:   path,
+			Reason: "path must begin with '/'",
+		}
+	}
+
+	root := new(node)
+	if tree != nil {
+		*root = *tree
+	}
+
+	if n := root.findRoute(path); n != nil && (len(matchers) > 0 || n.variants != nil) {
+		n = root.copyRoute(path)
+		vs := n.variants
+		if vs == nil {
+			// the handle registered so far serves the requests no matcher
+			// matches
+			vs = &variants{router: r}
+			if n.handle != nil {
+				vs.list = []variant{{handle: n.handle, meta: n.meta}}
+			}
+			n.meta = nil
+		} else if len(matchers) == 0 && vs.hasDefault() {
+			return nil, &RouteError{
+				Method:   method,
+				Path:     path,
+				Conflict: n.fullPath,
+				Reason:   "a handle is already registered for this path",
+			}
+		}
+		n.variants = vs.with(variant{matchers, handle, meta})
+		n.handle = n.variants.dispatch
+		return root, nil
+	}
+
+	if err := root.addRoute(path, handle); err != nil {
+		err.Method = method
+		return nil, err
+	}
+
+	// the route's node is a copy made by root.addRoute, it is not shared
+	n := root.findRoute(path)
+	if len(matchers) > 0 {
+		n.variants = &variants{router: r, list: []variant{{matchers, handle, meta}}}
+		n.handle = n.variants.dispatch
+	} else {
+		n.meta = meta
+	}
+	return root, nil
 }
 
 // Handler is an adapter which allows the usage of an http.Handler as a
@@ -280,10 +664,106 @@ func (r *Router) recv(w http.ResponseWriter, req *http.Request) {
 // values. Otherwise the third return value indicates whether a redirection to
 // the same path with an extra / without the trailing slash should be performed.
 func (r *Router) Lookup(method, path string) (Handle, Params, bool) {
-	if root := r.trees[method]; root != nil {
-		return root.getValue(path)
+	handle, ps, _, tsr := r.LookupRoute(method, path)
+	return handle, ps, tsr
+}
+
+// LookupRoute is like Lookup, but additionally returns the path of the matched
+// route, e.g. /user/:name for the path /user/gopher.
+func (r *Router) LookupRoute(method, path string) (Handle, Params, string, bool) {
+	host, p := splitHost(path)
+	handle, ps, pattern, route, tsr := r.loadHosts().lookup(host, method, p, nil)
+	return handle, ps, pattern + route, tsr
+}
+
+// allowed returns the methods allowed for the path, other than the requested
+// method, as the value of an Allow header. The path * stands for the whole
+// server, all methods of registered routes are allowed for it. The result is
+// empty if no other method is allowed.
+func (r *Router) allowed(hosts hostList, host, path, reqMethod string) string {
+	var allowed []string
+	for _, h := range hosts.matching(host) {
+		for method, root := range h.trees {
+			// Skip the requested method - we already tried this one
+			if method == reqMethod || contains(allowed, method) {
+				continue
+			}
+
+			if path == "*" {
+				allowed = append(allowed, method)
+			} else if handle, _, _, _ := root.getValue(path, nil); handle != nil {
+				allowed = append(allowed, method)
+			}
+		}
+	}
+
+	if len(allowed) == 0 {
+		return ""
+	}
+	// add the request methods served automatically
+	if r.HandleOPTIONS && !contains(allowed, "OPTIONS") {
+		allowed = append(allowed, "OPTIONS")
+	}
+	if r.HandleHEAD && reqMethod != "HEAD" && contains(allowed, "GET") && !contains(allowed, "HEAD") {
+		allowed = append(allowed, "HEAD")
+	}
+	sort.Strings(allowed)
+	return strings.Join(allowed, ", ")
+}
+
+// redirect redirects the request to the url, see Redirect.
+func (r *Router) redirect(w http.ResponseWriter, req *http.Request, url string, code int) {
+	if r.Redirect != nil {
+		r.Redirect(w, req, url, code)
+		return
+	}
+	w.Header().Set("Location", url)
+	w.WriteHeader(code)
+}
+
+// getParams returns empty Params from the pool, see putParams.
+func (r *Router) getParams() *Params {
+	if psp, ok := r.paramsPool.Get().(*Params); ok {
+		return psp
+	}
+	return new(Params)
+}
+
+// putParams returns the Params used for a request to the pool. The params may
+// have been grown by appending to them, the larger array is kept then.
+func (r *Router) putParams(psp *Params, ps Params) {
+	if cap(ps) > cap(*psp) {
+		*psp = ps
+	}
+	// don't keep the values alive
+	all := (*psp)[:cap(*psp)]
+	for i := range all {
+		all[i] = Param{}
+	}
+	*psp = all[:0]
+	r.paramsPool.Put(psp)
+}
+
+// setPath sets the path of the request URL to the path it was matched with,
+// which is escaped if UseRawPath is enabled.
+func (r *Router) setPath(req *http.Request, path string) {
+	if !r.UseRawPath {
+		req.URL.Path = path
+		return
+	}
+	if p, err := url.PathUnescape(path); err == nil {
+		req.URL.Path, req.URL.RawPath = p, path
+	}
+}
+
+// unescapeParams unescapes the values of params matched in an escaped path.
+// Values which aren't properly escaped are kept as they are.
+func unescapeParams(ps Params) {
+	for i := range ps {
+		if v, err := url.PathUnescape(ps[i].Value); err == nil {
+			ps[i].Value = v
+		}
 	}
-	return nil, nil, false
 }
 
 // ServeHTTP makes the router implement the http.Handler interface.
@@ -292,65 +772,125 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		defer r.recv(w, req)
 	}
 
-	if root := r.trees[req.Method]; root != nil {
-		path := req.URL.Path
+	// use the same routes for the whole request
+	r.serve(w, req, r.loadHosts(), "")
+}
+
+// serve serves the request with the routes. original is the path of the
+// request before it was corrected, if it was, see ServeFixedPath.
+func (r *Router) serve(w http.ResponseWriter, req *http.Request, hosts hostList, original string) {
+	path := req.URL.Path
+	if r.UseRawPath {
+		path = req.URL.EscapedPath()
+	}
+
+	psp := r.getParams()
+	handle, ps, pattern, route, tsr := hosts.lookup(req.Host, req.Method, path, *psp)
+	var hw *headWriter
+	if handle == nil && req.Method == "HEAD" && r.HandleHEAD {
+		var getTSR bool
+		if handle, ps, pattern, route, getTSR = hosts.lookup(req.Host, "GET", path, *psp); handle != nil {
+			hw = &headWriter{ResponseWriter: w}
+		}
+		tsr = tsr || getTSR
+	}
 
-		if handle, ps, tsr := root.getValue(path); handle != nil {
+	if handle != nil {
+		if r.UseRawPath {
+			unescapeParams(ps)
+		}
+		if r.SaveMatchedRoutePath {
+			ps = append(ps, Param{MatchedRoutePathParam, pattern + route})
+		}
+		if original != "" {
+			ps = append(ps, Param{OriginalPathParam, original})
+		}
+		buf := ps
+		if len(ps) == 0 {
+			ps = nil
+		}
+		if hw != nil {
+			handle(hw, req, ps)
+			hw.finish()
+		} else {
 			handle(w, req, ps)
-			return
-		} else if req.Method != "CONNECT" && path != "/" {
-			code := 301 // Permanent redirect, request with GET method
-			if req.Method != "GET" {
-				// Temporary redirect, request with same method
-				// As of Go 1.3, Go does not support status code 308.
-				code = 307
+		}
+		r.putParams(psp, buf)
+		return
+	}
+	r.putParams(psp, ps)
+
+	if req.Method != "CONNECT" && path != "/" && original == "" {
+		code := r.RedirectCode // Permanent redirect, request with GET method
+		if code == 0 {
+			code = http.StatusMovedPermanently
+		}
+		if req.Method != "GET" {
+			// Redirect which preserves the request method
+			code = r.RedirectMethodCode
+			if code == 0 {
+				code = http.StatusTemporaryRedirect
 			}
+		}
 
-			if tsr && r.RedirectTrailingSlash {
-				if len(path) > 1 && path[len(path)-1] == '/' {
-					req.URL.Path = path[:len(path)-1]
-				} else {
-					req.URL.Path = path + "/"
-				}
-				http.Redirect(w, req, req.URL.String(), code)
+		if tsr && r.RedirectTrailingSlash {
+			original = req.URL.Path
+			if len(path) > 1 && path[len(path)-1] == '/' {
+				r.setPath(req, path[:len(path)-1])
+			} else {
+				r.setPath(req, path+"/")
+			}
+			if r.ServeFixedPath {
+				r.serve(w, req, hosts, original)
 				return
 			}
+			r.redirect(w, req, req.URL.String(), code)
+			return
+		}
 
-			// Try to fix the request path
-			if r.RedirectFixedPath {
+		// Try to fix the request path
+		if r.RedirectFixedPath {
+			for _, root := range hosts.trees(req.Host, req.Method) {
 				fixedPath, found := root.findCaseInsensitivePath(
 					CleanPath(path),
 					r.RedirectTrailingSlash,
 				)
 				if found {
-					req.URL.Path = string(fixedPath)
-					http.Redirect(w, req, req.URL.String(), code)
+					original = req.URL.Path
+					r.setPath(req, string(fixedPath))
+					if r.ServeFixedPath {
+						r.serve(w, req, hosts, original)
+						return
+					}
+					r.redirect(w, req, req.URL.String(), code)
 					return
 				}
 			}
 		}
 	}
 
-	// Handle 405
-	if r.HandleMethodNotAllowed {
-		for method := range r.trees {
-			// Skip the requested method - we already tried this one
-			if method == req.Method {
-				continue
+	if req.Method == "OPTIONS" && r.HandleOPTIONS {
+		// Handle OPTIONS requests
+		if allow := r.allowed(hosts, req.Host, path, "OPTIONS"); allow != "" {
+			w.Header().Set("Allow", allow)
+			if r.GlobalOPTIONS != nil {
+				r.GlobalOPTIONS.ServeHTTP(w, req)
 			}
-
-			handle, _, _ := r.trees[method].getValue(req.URL.Path)
-			if handle != nil {
-				if r.MethodNotAllowed != nil {
-					r.MethodNotAllowed.ServeHTTP(w, req)
-				} else {
-					http.Error(w,
-						http.StatusText(http.StatusMethodNotAllowed),
-						http.StatusMethodNotAllowed,
-					)
-				}
-				return
+			return
+		}
+	} else if r.HandleMethodNotAllowed {
+		// Handle 405
+		if allow := r.allowed(hosts, req.Host, path, req.Method); allow != "" {
+			w.Header().Set("Allow", allow)
+			if r.MethodNotAllowed != nil {
+				r.MethodNotAllowed.ServeHTTP(w, req)
+			} else {
+				http.Error(w,
+					http.StatusText(http.StatusMethodNotAllowed),
+					http.StatusMethodNotAllowed,
+				)
 			}
+			return
 		}
 	}
 
@@ -361,3 +901,39 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		http.NotFound(w, req)
 	}
 }
+
+// headWriter is the http.ResponseWriter for HEAD requests served by a GET
+// handle. It discards the body, but derives the Content-Length and
+// Content-Type headers from it. The header is written by finish.
+type headWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *headWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if w.written == 0 && len(p) > 0 && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", http.DetectContentType(p))
+	}
+	w.WriteHeader(http.StatusOK)
+	w.written += int64(len(p))
+	return len(p), nil
+}
+
+// finish writes the header once the handle returned.
+func (w *headWriter) finish() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	h := w.Header()
+	if w.written > 0 && h.Get("Content-Length") == "" && h.Get("Transfer-Encoding") == "" {
+		h.Set("Content-Length", strconv.FormatInt(w.written, 10))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
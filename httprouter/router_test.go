@@ -5,11 +5,14 @@
 package httprouter
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -67,6 +70,38 @@ func TestRouter(t *testing.T) {
 	}
 }
 
+func TestRouterParamConstraint(t *testing.T) {
+	router := New()
+
+	var routed string
+	router.GET("/user/new", func(w http.ResponseWriter, r *http.Request, _ Params) {
+		routed = "new"
+	})
+	router.GET("/user/:id{int}", func(w http.ResponseWriter, r *http.Request, ps Params) {
+		routed = "id " + ps.ByName("id")
+	})
+
+	tests := []struct {
+		path   string
+		routed string
+		code   int
+	}{
+		{"/user/new", "new", http.StatusOK},
+		{"/user/42", "id 42", http.StatusOK},
+		{"/user/gopher", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		routed = ""
+		r, _ := http.NewRequest("GET", test.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if routed != test.routed || w.Code != test.code {
+			t.Errorf("routing %s failed: routed=%q, code=%d; want %q, %d",
+				test.path, routed, w.Code, test.routed, test.code)
+		}
+	}
+}
+
 type handlerStruct struct {
 	handeled *bool
 }
@@ -174,6 +209,446 @@ func TestRouterRoot(t *testing.T) {
 	}
 }
 
+func TestRouterRegister(t *testing.T) {
+	router := New()
+
+	handle := func(_ http.ResponseWriter, _ *http.Request, _ Params) {}
+	if err := router.Register("GET", "/user/:name", handle); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := router.Register("GET", "/user/:id/profile", handle)
+	if err == nil {
+		t.Fatal("registering a conflicting route did not fail")
+	}
+	rerr, ok := err.(*RouteError)
+	if !ok {
+		t.Fatalf("expected *RouteError, got %T", err)
+	}
+	if rerr.Method != "GET" || rerr.Path != "/user/:id/profile" || rerr.Conflict != "/user/:name" {
+		t.Errorf("wrong error: %+v", rerr)
+	}
+	want := "GET /user/:id/profile: wildcard ':id' conflicts with existing wildcard ':name' (registered route '/user/:name')"
+	if err.Error() != want {
+		t.Errorf("wrong error message:\n got %s\nwant %s", err.Error(), want)
+	}
+
+	if err := router.Register("GET", "noSlashRoot", handle); err == nil {
+		t.Error("registering path not beginning with '/' did not fail")
+	}
+
+	// the failed registrations must not affect the router
+	if handle, ps, _ := router.Lookup("GET", "/user/gopher"); handle == nil || ps.ByName("name") != "gopher" {
+		t.Error("routing failed after failed registrations")
+	}
+}
+
+func TestRouterRemove(t *testing.T) {
+	router := New()
+
+	handle := func(_ http.ResponseWriter, _ *http.Request, _ Params) {}
+	router.GET("/user/:name", handle)
+	router.GET("/user/:id{int}/posts", handle)
+	router.GET("/user/:id{hex}/posts", handle)
+	router.POST("/user/:name", handle)
+	router.RegisterMeta("GET", "/src/*filepath", handle, "files")
+
+	if err := router.Remove("GET", "/user/:name"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := router.Remove("GET", "/user/:name"); err == nil {
+		t.Error("removing a removed route did not fail")
+	}
+	if err := router.Remove("GET", "/user/:id"); err == nil {
+		t.Error("removing an unknown route did not fail")
+	}
+
+	if handle, _, _ := router.Lookup("GET", "/user/gopher"); handle != nil {
+		t.Error("removed route is still routed")
+	}
+	if handle, _, _ := router.Lookup("POST", "/user/gopher"); handle == nil {
+		t.Error("route of another method was removed")
+	}
+	for path, want := range map[string]string{
+		"/user/42/posts": "/user/:id{int}/posts",
+		"/user/ab/posts": "/user/:id{hex}/posts",
+		"/src/LICENSE":   "/src/*filepath",
+	} {
+		if _, _, route, _ := router.LookupRoute("GET", path); route != want {
+			t.Errorf("%s: wrong route after removal, want %s, got %q", path, want, route)
+		}
+	}
+
+	var meta interface{}
+	router.Walk(func(route Route, _ Handle) error {
+		if route.Path == "/src/*filepath" {
+			meta = route.Meta
+		}
+		return nil
+	})
+	if meta != "files" {
+		t.Errorf("metadata lost after removal: %v", meta)
+	}
+
+	// the wildcard of the removed route doesn't conflict anymore
+	if err := router.Register("GET", "/user/:login", handle); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// removing the last route of a method removes its tree
+	router.Remove("POST", "/user/:name")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/user/gopher", nil)
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("wrong status code after removal, want 405, got %d", w.Code)
+	}
+}
+
+func TestRouterRemoveEach(t *testing.T) {
+	routes := []struct {
+		path, req, route string
+	}{
+		{"/doc/", "/doc/", "/doc/"},
+		{"/user/:id{int}", "/user/42", "/user/:id{int}"},
+		{"/user/:name", "/user/gopher", "/user/:name"},
+		{"/files/:name.:ext", "/files/app.js", "/files/:name.:ext"},
+		{"/items/:page?", "/items", "/items"},
+		{"/src/*filepath", "/src/LICENSE", "/src/*filepath"},
+		{"/src/:file{int}/x", "/src/7/x", "/src/:file{int}/x"},
+		{"/archive_:year{int}", "/archive_2024", "/archive_:year{int}"},
+	}
+	handle := func(_ http.ResponseWriter, _ *http.Request, _ Params) {}
+
+	for _, removed := range routes {
+		router := New()
+		for _, r := range routes {
+			if err := router.Register("GET", r.path, handle); err != nil {
+				t.Fatalf("%s: unexpected error: %v", r.path, err)
+			}
+		}
+		if err := router.Remove("GET", removed.path); err != nil {
+			t.Errorf("%s: unexpected error: %v", removed.path, err)
+			continue
+		}
+		for _, r := range routes {
+			_, _, route, _ := router.LookupRoute("GET", r.req)
+			if r == removed && route == r.route {
+				t.Errorf("%s: removed route is still routed", r.path)
+			} else if r != removed && route != r.route {
+				t.Errorf("%s removed: wrong route for %s, want %s, got %q", removed.path, r.req, r.route, route)
+			}
+		}
+	}
+}
+
+func TestRouterConcurrentChanges(t *testing.T) {
+	router := New()
+	handle := func(_ http.ResponseWriter, _ *http.Request, _ Params) {}
+	router.GET("/user/:name", handle)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := new(mockResponseWriter)
+			r, _ := http.NewRequest("GET", "/user/gopher", nil)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if handle, _, _ := router.Lookup("GET", "/user/gopher"); handle == nil {
+					t.Error("route got lost while other routes changed")
+					return
+				}
+				router.ServeHTTP(w, r)
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		path := fmt.Sprintf("/route/%d/:id", i)
+		if err := router.Register("GET", path, handle); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if i%2 == 0 {
+			if err := router.Remove("GET", path); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	count := 0
+	router.Walk(func(Route, Handle) error {
+		count++
+		return nil
+	})
+	if count != 51 {
+		t.Errorf("wrong number of routes, want 51, got %d", count)
+	}
+}
+
+func TestRouterHost(t *testing.T) {
+	router := New()
+
+	var served string
+	var params Params
+	named := func(name string) Handle {
+		return func(_ http.ResponseWriter, _ *http.Request, ps Params) {
+			served, params = name, append(Params(nil), ps...)
+		}
+	}
+	router.GET("/users/:id", named("default"))
+	router.GET("/health", named("health"))
+	router.GET("*.example.com/users/:id", named("wildcard"))
+	router.GET(":tenant.example.com/users/:id", named("tenant"))
+	router.GET("api.example.com/users/:id", named("api"))
+	router.GET("api.example.com/status", named("status"))
+	router.GET(":tenant.example.com/orders", named("orders"))
+
+	tests := []struct {
+		host, path string
+		name       string
+		ps         Params
+	}{
+		{"api.example.com", "/users/1", "api", Params{{"id", "1"}}},
+		{"API.example.com:8080", "/users/1", "api", Params{{"id", "1"}}},
+		{"acme.example.com", "/users/1", "wildcard", Params{{"id", "1"}}},
+		{"example.com", "/users/1", "default", Params{{"id", "1"}}},
+		{"a.b.example.com", "/users/1", "default", Params{{"id", "1"}}},
+		{"api.example.com", "/health", "health", nil},
+		{"api.example.com", "/status", "status", nil},
+		{"api.example.com", "/orders", "orders", Params{{"tenant", "api"}}},
+	}
+	for _, test := range tests {
+		served, params = "", nil
+		r, _ := http.NewRequest("GET", test.path, nil)
+		r.Host = test.host
+		router.ServeHTTP(new(mockResponseWriter), r)
+		if served != test.name || !reflect.DeepEqual(params, test.ps) {
+			t.Errorf("%s%s: want %s %v, got %s %v", test.host, test.path, test.name, test.ps, served, params)
+		}
+	}
+
+	// the methods of all matching hosts are allowed
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/orders", nil)
+	r.Host = "api.example.com"
+	router.ServeHTTP(w, r)
+	if allow := w.Header().Get("Allow"); w.Code != http.StatusMethodNotAllowed || allow != "GET, OPTIONS" {
+		t.Errorf("wrong status code or Allow header, want 405 GET, OPTIONS, got %d %s", w.Code, allow)
+	}
+
+	// without its routes the wildcard host is gone and the param host matches
+	router.Remove("GET", "*.example.com/users/:id")
+	handle, ps, route, _ := router.LookupRoute("GET", "acme.example.com/users/1")
+	if handle == nil {
+		t.Fatal("no handle for param host")
+	}
+	if want := (Params{{"tenant", "acme"}, {"id", "1"}}); !reflect.DeepEqual(ps, want) {
+		t.Errorf("wrong params, want %v, got %v", want, ps)
+	}
+	if route != ":tenant.example.com/users/:id" {
+		t.Errorf("wrong route, got %s", route)
+	}
+
+	if err := router.Register("GET", "a..b/", named("malformed")); err == nil {
+		t.Error("registering a malformed host did not fail")
+	}
+	err := router.Register("GET", "api.example.com/users/:name", named("conflict"))
+	if rerr, ok := err.(*RouteError); !ok || rerr.Conflict != "api.example.com/users/:id" {
+		t.Errorf("wrong conflict error: %v", err)
+	}
+
+	var routes []string
+	router.Walk(func(route Route, _ Handle) error {
+		routes = append(routes, route.Path+fmt.Sprint(route.Params))
+		return nil
+	})
+	want := []string{"/health[]", "/users/:id[id]", ":tenant.example.com/orders[tenant]", ":tenant.example.com/users/:id[tenant id]", "api.example.com/status[]", "api.example.com/users/:id[id]"}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("wrong routes:\n got %v\nwant %v", routes, want)
+	}
+}
+
+func TestRouterMatchers(t *testing.T) {
+	router := New()
+
+	var served string
+	named := func(name string) Handle {
+		return func(_ http.ResponseWriter, _ *http.Request, _ Params) {
+			served = name
+		}
+	}
+	router.GET("/users/:id", named("default"))
+	if err := router.RegisterMatch("GET", "/users/:id", named("v2"), nil, MediaType("application/vnd.acme.v2+json")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	router.RegisterMatch("GET", "/users/:id", named("v3"), nil, Header("Api-Version", "3"))
+	router.RegisterMatch("GET", "/users/:id", named("beta"), nil, Query("beta", ""), Header("Api-Version", ""))
+	router.RegisterMatch("GET", "/items", named("v2 items"), nil, MediaType("application/vnd.acme.v2+json"))
+
+	tests := []struct {
+		path   string
+		header http.Header
+		name   string
+		code   int
+	}{
+		{"/users/1", nil, "default", 200},
+		{"/users/1", http.Header{"Accept": {"text/html, application/vnd.acme.v2+json;q=0.9"}}, "v2", 200},
+		{"/users/1", http.Header{"Accept": {"application/vnd.acme.v2+json;q=0"}}, "default", 200},
+		{"/users/1", http.Header{"Accept": {"*/*"}}, "default", 200},
+		{"/users/1", http.Header{"Api-Version": {"3"}}, "v3", 200},
+		{"/users/1?beta=1", http.Header{"Api-Version": {"4"}}, "beta", 200},
+		{"/users/1?beta=1", nil, "default", 200},
+		{"/items", http.Header{"Accept": {"application/vnd.acme.v2+json"}}, "v2 items", 200},
+		{"/items", http.Header{"Accept": {"application/json"}}, "", http.StatusNotAcceptable},
+	}
+	for _, test := range tests {
+		served = ""
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", test.path, nil)
+		r.Header = test.header
+		router.ServeHTTP(w, r)
+		if served != test.name || w.Code != test.code {
+			t.Errorf("%s %v: want %q %d, got %q %d", test.path, test.header, test.name, test.code, served, w.Code)
+		}
+	}
+
+	if err := router.Register("GET", "/users/:id", named("default")); err == nil {
+		t.Error("registering a second handle without matchers did not fail")
+	}
+
+	// the handle without matchers can be added later
+	router.GET("/items", named("items"))
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/items", nil)
+	router.ServeHTTP(w, r)
+	if served != "items" {
+		t.Errorf("wrong handle, want items, got %q", served)
+	}
+
+	var count int
+	router.Walk(func(route Route, _ Handle) error {
+		count++
+		return nil
+	})
+	if count != 6 {
+		t.Errorf("wrong number of routes, want 6, got %d", count)
+	}
+
+	// the variants are kept when other routes are removed, and removed with
+	// their route
+	router.GET("/other", named("other"))
+	router.Remove("GET", "/other")
+	r, _ = http.NewRequest("GET", "/users/1", nil)
+	r.Header.Set("Api-Version", "3")
+	router.ServeHTTP(w, r)
+	if served != "v3" {
+		t.Errorf("wrong handle after removing another route, want v3, got %q", served)
+	}
+	router.Remove("GET", "/users/:id")
+	if handle, _, _ := router.Lookup("GET", "/users/1"); handle != nil {
+		t.Error("handles with matchers are still routed after removal")
+	}
+}
+
+func TestRouterValidate(t *testing.T) {
+	router := New()
+	router.GET("/user/:name", func(_ http.ResponseWriter, _ *http.Request, _ Params) {})
+
+	if err := router.Validate([]Route{
+		{Method: "GET", Path: "/user/:name/profile"},
+		{Method: "POST", Path: "/user/:id"},
+		{Method: "GET", Path: "/static/*filepath"},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := router.Validate([]Route{
+		{Method: "GET", Path: "/user/:id"},
+		{Method: "POST", Path: "/user/new"},
+		{Method: "POST", Path: "/user/new"},
+		{Method: "PUT", Path: "/user/:id{int"},
+	})
+	errs, ok := err.(RouteErrors)
+	if !ok {
+		t.Fatalf("expected RouteErrors, got %T: %v", err, err)
+	}
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %d: %v", len(errs), err)
+	}
+	if errs[0].Path != "/user/:id" || errs[0].Conflict != "/user/:name" {
+		t.Errorf("wrong error: %v", errs[0])
+	}
+	if errs[1].Method != "POST" || errs[1].Conflict != "/user/new" {
+		t.Errorf("wrong error: %v", errs[1])
+	}
+	if errs[2].Method != "PUT" || errs[2].Conflict != "" {
+		t.Errorf("wrong error: %v", errs[2])
+	}
+
+	// nothing must be registered
+	if handle, _, _ := router.Lookup("POST", "/user/new"); handle != nil {
+		t.Error("Validate registered a route")
+	}
+}
+
+func TestRouterWalk(t *testing.T) {
+	router := New()
+
+	handle := func(_ http.ResponseWriter, _ *http.Request, _ Params) {}
+	router.GET("/user/:name", handle)
+	router.GET("/", handle)
+	router.GET("/files/:dir/*filepath", handle)
+	router.POST("/user/:id{int}/posts", handle)
+	if err := router.RegisterMeta("DELETE", "/user/:name", handle, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	router.GET("/user/new", handle)
+
+	var routes []Route
+	err := router.Walk(func(route Route, h Handle) error {
+		if h == nil {
+			t.Errorf("nil handle for route %s %s", route.Method, route.Path)
+		}
+		routes = append(routes, route)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []Route{
+		{"DELETE", "/user/:name", []string{"name"}, "admin", nil},
+		{"GET", "/", nil, nil, nil},
+		{"GET", "/files/:dir/*filepath", []string{"dir", "filepath"}, nil, nil},
+		{"GET", "/user/:name", []string{"name"}, nil, nil},
+		{"GET", "/user/new", nil, nil, nil},
+		{"POST", "/user/:id{int}/posts", []string{"id"}, nil, nil},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("wrong routes:\n got %v\nwant %v", routes, want)
+	}
+
+	// Walk stops at the first error
+	stop := errors.New("stop")
+	calls := 0
+	err = router.Walk(func(route Route, h Handle) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Walk did not stop: err=%v, calls=%d", err, calls)
+	}
+}
+
 func TestRouterChaining(t *testing.T) {
 	router1 := New()
 	router2 := New()
@@ -228,6 +703,22 @@ func TestRouterNotAllowed(t *testing.T) {
 	router.ServeHTTP(w, r)
 	if !(w.Code == http.StatusMethodNotAllowed) {
 		t.Errorf("NotAllowed handling failed: Code=%d, Header=%v", w.Code, w.Header())
+	} else if allow := w.Header().Get("Allow"); allow != "OPTIONS, POST" {
+		t.Error("unexpected Allow header value: " + allow)
+	}
+
+	// add another method
+	router.DELETE("/path", handlerFunc)
+	router.OPTIONS("/path", handlerFunc) // must be ignored
+
+	// test again
+	r, _ = http.NewRequest("GET", "/path", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if !(w.Code == http.StatusMethodNotAllowed) {
+		t.Errorf("NotAllowed handling failed: Code=%d, Header=%v", w.Code, w.Header())
+	} else if allow := w.Header().Get("Allow"); allow != "DELETE, OPTIONS, POST" {
+		t.Error("unexpected Allow header value: " + allow)
 	}
 
 	w = httptest.NewRecorder()
@@ -243,6 +734,71 @@ func TestRouterNotAllowed(t *testing.T) {
 	if w.Code != http.StatusTeapot {
 		t.Errorf("unexpected response code %d want %d", w.Code, http.StatusTeapot)
 	}
+	if allow := w.Header().Get("Allow"); allow != "DELETE, OPTIONS, POST" {
+		t.Error("unexpected Allow header value: " + allow)
+	}
+}
+
+func TestRouterOPTIONS(t *testing.T) {
+	handlerFunc := func(_ http.ResponseWriter, _ *http.Request, _ Params) {}
+
+	router := New()
+	router.POST("/path", handlerFunc)
+	router.GET("/user/:name", handlerFunc)
+
+	for path, want := range map[string]string{
+		"/path":        "OPTIONS, POST",
+		"*":            "GET, OPTIONS, POST",
+		"/user/gopher": "GET, OPTIONS",
+	} {
+		r, _ := http.NewRequest("OPTIONS", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: OPTIONS handling failed: Code=%d, Header=%v", path, w.Code, w.Header())
+		} else if allow := w.Header().Get("Allow"); allow != want {
+			t.Errorf("%s: unexpected Allow header value: %s", path, allow)
+		}
+	}
+
+	r, _ := http.NewRequest("OPTIONS", "/doesnotexist", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("OPTIONS handling failed: Code=%d, Header=%v", w.Code, w.Header())
+	}
+
+	// the global OPTIONS handler is called after the Allow header is set
+	router.GlobalOPTIONS = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Methods", w.Header().Get("Allow"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	r, _ = http.NewRequest("OPTIONS", "/path", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") != "OPTIONS, POST" {
+		t.Errorf("GlobalOPTIONS handling failed: Code=%d, Header=%v", w.Code, w.Header())
+	}
+
+	// a custom handle takes priority
+	custom := false
+	router.OPTIONS("/path", func(_ http.ResponseWriter, _ *http.Request, _ Params) {
+		custom = true
+	})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if !custom {
+		t.Error("custom OPTIONS handle was not called")
+	}
+
+	// disabled, OPTIONS is not allowed then
+	router.HandleOPTIONS = false
+	r, _ = http.NewRequest("OPTIONS", "/user/gopher", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET" {
+		t.Errorf("OPTIONS handling failed: Code=%d, Header=%v", w.Code, w.Header())
+	}
 }
 
 func TestRouterNotFound(t *testing.T) {
@@ -310,6 +866,285 @@ func TestRouterNotFound(t *testing.T) {
 	}
 }
 
+func TestRouterRawPath(t *testing.T) {
+	var got Params
+	handle := func(_ http.ResponseWriter, _ *http.Request, ps Params) {
+		got = append(Params(nil), ps...)
+	}
+	router := New()
+	router.GET("/objects/:key", handle)
+	router.GET("/objects/:key/acl", handle)
+	router.GET("/files/*filepath", handle)
+	router.GET("/a%20b/:c", handle)
+
+	tests := []struct {
+		path string
+		ps   Params
+	}{
+		{"/objects/a%2Fb", Params{{"key", "a/b"}}},
+		{"/objects/a%2Fb/acl", Params{{"key", "a/b"}}},
+		{"/objects/a%3Fb%20c", Params{{"key", "a?b c"}}},
+		{"/objects/plain", Params{{"key", "plain"}}},
+		{"/files/dir/a%2Fb", Params{{"filepath", "/dir/a/b"}}},
+		{"/a%20b/c%25", Params{{"c", "c%"}}},
+	}
+
+	// the unescaped path is matched by default
+	r, _ := http.NewRequest("GET", "/objects/a%2Fb", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected the unescaped path to be matched, got status %d", w.Code)
+	}
+
+	router.UseRawPath = true
+	for _, test := range tests {
+		got = nil
+		r, _ := http.NewRequest("GET", test.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if !reflect.DeepEqual(got, test.ps) {
+			t.Errorf("wrong params for %s: got %v, want %v", test.path, got, test.ps)
+		}
+	}
+
+	// redirects keep the escaping
+	r, _ = http.NewRequest("GET", "/objects/a%2Fb/acl/", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if location := w.Header().Get("Location"); w.Code != http.StatusMovedPermanently || location != "/objects/a%2Fb/acl" {
+		t.Errorf("wrong redirect: Code=%d, Location=%q", w.Code, location)
+	}
+}
+
+func TestRouterOptional(t *testing.T) {
+	var got Params
+	handle := func(_ http.ResponseWriter, _ *http.Request, ps Params) {
+		got = append(Params(nil), ps...)
+	}
+	router := New()
+	router.GET("/items/:page?=1", handle)
+	router.GET("/archive(/:year(/:month=01))", handle)
+
+	tests := []struct {
+		path string
+		ps   Params
+	}{
+		{"/items", Params{{"page", "1"}}},
+		{"/items/3", Params{{"page", "3"}}},
+		{"/archive", Params{{"month", "01"}}},
+		{"/archive/2024", Params{{"year", "2024"}, {"month", "01"}}},
+		{"/archive/2024/05", Params{{"year", "2024"}, {"month", "05"}}},
+	}
+	for _, test := range tests {
+		got = nil
+		r, _ := http.NewRequest("GET", test.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if !reflect.DeepEqual(got, test.ps) {
+			t.Errorf("wrong params for %s: got %v, want %v", test.path, got, test.ps)
+		}
+	}
+
+	// all routes of a pattern conflict
+	err := router.Register("GET", "/items(/:id)", handle)
+	if rerr, ok := err.(*RouteError); !ok || rerr.Path != "/items(/:id)" {
+		t.Errorf("expected a conflict for the pattern, got %v", err)
+	}
+	if err := router.Register("GET", "/bad/x:id?", handle); err == nil {
+		t.Error("no error for an optional param within a segment")
+	}
+
+	if err := router.Remove("GET", "/items/:page?=1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, path := range []string{"/items", "/items/3"} {
+		if handle, _, _ := router.Lookup("GET", path); handle != nil {
+			t.Errorf("%s: removed route is still routed", path)
+		}
+	}
+}
+
+func TestRouterPrintTree(t *testing.T) {
+	router := New()
+	handle := func(_ http.ResponseWriter, _ *http.Request, _ Params) {}
+	for _, route := range []string{"/", "/users", "/users/:id{int}", "/users/:name", "/src/*filepath", "api.example.com/health"} {
+		router.GET(route, handle)
+	}
+	router.RegisterMatch("GET", "/users", handle, nil, Header("Api-Version", "2"))
+
+	var buf bytes.Buffer
+	if err := router.PrintTree(&buf, "GET"); err != nil {
+		t.Fatal(err)
+	}
+	want := `[api.example.com]
+"/health" root priority=1 maxParams=0 handle=/health
+[no host]
+"/" root priority=5 maxParams=1 indices="us" handle=/
+  "users" static priority=3 maxParams=1 indices="/" handle=/users variants=2
+    "/" static priority=2 maxParams=1 wildChild
+      ":id{int}" param priority=1 maxParams=1 handle=/users/:id{int}
+      ":name" param priority=1 maxParams=1 handle=/users/:name
+  "src/" static priority=1 maxParams=1 wildChild
+    "*filepath" catchAll priority=1 maxParams=1 handle=/src/*filepath
+`
+	if buf.String() != want {
+		t.Errorf("wrong tree:\n got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := router.PrintTreeDOT(&buf, "GET"); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	for _, want := range []string{
+		"digraph \"GET\" {\n",
+		"\tsubgraph cluster_0 {\n\t\tlabel=\"api.example.com\";\n",
+		"\tn3 [label=\"\\\"/\\\"\\nstatic priority=2 maxParams=1 wildChild\"];\n",
+		"\tn3 -> n4 [style=dashed];\n",
+		"\tn1 -> n2 [label=\"u\"];\n",
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT output lacks %q:\n%s", want, dot)
+		}
+	}
+
+	buf.Reset()
+	router.PrintTree(&buf, "POST")
+	if buf.Len() != 0 {
+		t.Errorf("expected no tree for POST, got:\n%s", buf.String())
+	}
+}
+
+func TestRouterServeFixedPath(t *testing.T) {
+	var path, original string
+	handle := func(_ http.ResponseWriter, req *http.Request, ps Params) {
+		path, original = req.URL.Path, ps.OriginalPath()
+	}
+	router := New()
+	router.GET("/users", handle)
+	router.POST("/items/", handle)
+	router.GET("/files/:name", handle)
+	router.ServeFixedPath = true
+
+	tests := []struct {
+		method, path    string
+		canonical, orig string
+	}{
+		{"GET", "/users", "/users", ""},
+		{"GET", "/users/", "/users", "/users/"},
+		{"GET", "/USERS", "/users", "/USERS"},
+		{"POST", "/items", "/items/", "/items"},
+		{"POST", "/../ITEMS", "/items/", "/../ITEMS"},
+		{"GET", "/FILES/Report.PDF", "/files/Report.PDF", "/FILES/Report.PDF"},
+	}
+	for _, test := range tests {
+		path, original = "", ""
+		r, _ := http.NewRequest(test.method, test.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || path != test.canonical || original != test.orig {
+			t.Errorf("%s %s: got %d %q %q, want 200 %q %q",
+				test.method, test.path, w.Code, path, original, test.canonical, test.orig)
+		}
+	}
+
+	// the corrections themselves can still be disabled
+	router.RedirectFixedPath = false
+	r, _ := http.NewRequest("GET", "/USERS", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without fixed paths, got %d", w.Code)
+	}
+}
+
+func TestRouterHEAD(t *testing.T) {
+	router := New()
+	router.GET("/page", func(w http.ResponseWriter, _ *http.Request, _ Params) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("<html><body>page</body></html>"))
+	})
+	router.GET("/created", func(w http.ResponseWriter, _ *http.Request, _ Params) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	})
+	router.HEAD("/created", func(w http.ResponseWriter, _ *http.Request, _ Params) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// disabled by default
+	r, _ := http.NewRequest("HEAD", "/page", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("HEAD handling failed: Code=%d, Header=%v", w.Code, w.Header())
+	}
+
+	router.HandleHEAD = true
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("HEAD handling failed: Code=%d, Body=%q", w.Code, w.Body.String())
+	}
+	want := http.Header{
+		"Etag":           {`"v1"`},
+		"Content-Length": {"30"},
+		"Content-Type":   {"text/html; charset=utf-8"},
+	}
+	if !reflect.DeepEqual(w.Header(), want) {
+		t.Errorf("wrong headers:\n got %v\nwant %v", w.Header(), want)
+	}
+
+	// a HEAD handle takes priority
+	r, _ = http.NewRequest("HEAD", "/created", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("HEAD handle not called: Code=%d", w.Code)
+	}
+
+	// HEAD is allowed along with GET
+	r, _ = http.NewRequest("POST", "/page", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, OPTIONS" {
+		t.Error("unexpected Allow header value: " + allow)
+	}
+}
+
+func TestRouterRedirect(t *testing.T) {
+	handlerFunc := func(_ http.ResponseWriter, _ *http.Request, _ Params) {}
+
+	router := New()
+	router.GET("/path", handlerFunc)
+	router.POST("/path", handlerFunc)
+	router.RedirectCode = http.StatusPermanentRedirect
+	router.RedirectMethodCode = http.StatusPermanentRedirect
+
+	for _, method := range []string{"GET", "POST"} {
+		r, _ := http.NewRequest(method, "/path/", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if !(w.Code == 308 && fmt.Sprint(w.Header()) == "map[Location:[/path]]") {
+			t.Errorf("%s: redirect failed: Code=%d, Header=%v", method, w.Code, w.Header())
+		}
+	}
+
+	var redirected string
+	router.Redirect = func(w http.ResponseWriter, req *http.Request, url string, code int) {
+		redirected = url
+		w.WriteHeader(code)
+	}
+	r, _ := http.NewRequest("GET", "/PATH", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != 308 || redirected != "/path" {
+		t.Errorf("custom redirect failed: Code=%d, url=%q", w.Code, redirected)
+	}
+}
+
 func TestRouterPanicHandler(t *testing.T) {
 	router := New()
 	panicHandled := false
@@ -390,6 +1225,43 @@ func TestRouterLookup(t *testing.T) {
 	}
 }
 
+func TestRouterMatchedRoutePath(t *testing.T) {
+	var route string
+	handle := func(_ http.ResponseWriter, _ *http.Request, ps Params) {
+		route = ps.MatchedRoutePath()
+	}
+
+	router := New()
+	router.GET("/user/:name", handle)
+	router.GET("/src/*filepath", handle)
+
+	_, _, pattern, _ := router.LookupRoute("GET", "/user/gopher")
+	if pattern != "/user/:name" {
+		t.Errorf("LookupRoute: wrong route, want /user/:name, got %q", pattern)
+	}
+
+	w := new(mockResponseWriter)
+	r, _ := http.NewRequest("GET", "/user/gopher", nil)
+	router.ServeHTTP(w, r)
+	if route != "" {
+		t.Errorf("route saved without SaveMatchedRoutePath: %q", route)
+	}
+
+	router.SaveMatchedRoutePath = true
+	for path, want := range map[string]string{
+		"/user/gopher":   "/user/:name",
+		"/src/some/file": "/src/*filepath",
+		"/src/":          "/src/*filepath",
+	} {
+		route = ""
+		r, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, r)
+		if route != want {
+			t.Errorf("%s: wrong matched route, want %q, got %q", path, want, route)
+		}
+	}
+}
+
 type mockFileSystem struct {
 	opened bool
 }
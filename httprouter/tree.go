@@ -19,10 +19,15 @@ func min(a, b int) int {
 func countParams(path string) uint8 {
 	var n uint
 	for i := 0; i < len(path); i++ {
-		if path[i] != ':' && path[i] != '*' {
-			continue
+		switch path[i] {
+		case ':', '*':
+			n++
+		case '{':
+			// skip the constraint, it may contain ':' and '*' as well
+			for i < len(path) && path[i] != '}' {
+				i++
+			}
 		}
-		n++
 	}
 	if n >= 255 {
 		return 255
@@ -39,6 +44,10 @@ const (
 	catchAll
 )
 
+// The children of a node are ordered. The static children come first and are
+// indexed by the first byte of their path in indices. They are followed by the
+// wildcard children, if any: params with a constraint, the unconstrained
+// param and the catch-all, in this order.
 type node struct {
 	path      string
 	wildChild bool
@@ -48,6 +57,18 @@ type node struct {
 	children  []*node
 	handle    Handle
 	priority  uint32
+
+	// name and constraint of a wildcard node
+	key   string
+	check *constraint
+
+	// path and metadata of the route ending in this node, if any
+	fullPath string
+	meta     interface{}
+
+	// handles of the route selected by matchers, if any. The handle of the
+	// node dispatches to them.
+	variants *variants
 }
 
 // increments priority of the given child and reorders if necessary
@@ -76,63 +97,241 @@ func (n *node) incrementChildPrio(pos int) int {
 	return newPos
 }
 
+// addStaticChild inserts a static child behind the existing static children,
+// in front of the wildcard children, and returns its position.
+func (n *node) addStaticChild(c byte, child *node) int {
+	pos := len(n.indices)
+	// []byte for proper unicode char conversion, see #65
+	n.indices += string([]byte{c})
+	n.children = append(n.children, nil)
+	copy(n.children[pos+1:], n.children[pos:])
+	n.children[pos] = child
+	return pos
+}
+
+// wildRank orders the wildcard children of a node by the order in which they
+// are tried during the lookup.
+func (n *node) wildRank() int {
+	switch {
+	case n.nType == param && n.check != nil:
+		return 0
+	case n.nType == param:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// addWildChild inserts a wildcard child behind all wildcard children which
+// are tried before it.
+func (n *node) addWildChild(child *node) {
+	pos := len(n.children)
+	for pos > len(n.indices) && n.children[pos-1].wildRank() > child.wildRank() {
+		pos--
+	}
+	n.children = append(n.children, nil)
+	copy(n.children[pos+1:], n.children[pos:])
+	n.children[pos] = child
+	n.wildChild = true
+}
+
+// sameWildChild returns the wildcard child which is tried at the same rank
+// as the given one, if any. There can be only one unconstrained param and one
+// catch-all per node, since they match any path segment.
+func (n *node) sameWildChild(child *node) *node {
+	rank := child.wildRank()
+	for _, c := range n.children[len(n.indices):] {
+		if c.wildRank() == rank {
+			return c
+		}
+	}
+	return nil
+}
+
+// catchAllChild returns the catch-all child of the node, if any.
+func (n *node) catchAllChild() *node {
+	if n.wildChild {
+		if child := n.children[len(n.children)-1]; child.nType == catchAll {
+			return child
+		}
+	}
+	return nil
+}
+
+// wildcardEnd returns the end of the wildcard at the beginning of path. The
+// name of a wildcard consists of letters, digits and '_' and may be followed
+// by a constraint in braces. Anything after it is static text, e.g. the
+// ".csv" of /reports/:date.csv.
+func wildcardEnd(path string) int {
+	end := 1
+	for end < len(path) && isNameChar(path[end]) {
+		end++
+	}
+	if end < len(path) && path[end] == '{' {
+		// the constraint ends with the matching closing brace
+		depth := 0
+		for ; end < len(path); end++ {
+			switch path[end] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					return end + 1
+				}
+			}
+		}
+	}
+	return end
+}
+
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// inSegment reports whether static text follows the param node within the
+// path segment, i.e. whether it has static children not beginning with '/'.
+func (n *node) inSegment() bool {
+	return len(n.indices) > 1 || (len(n.indices) == 1 && n.indices[0] != '/')
+}
+
+// copyChild replaces the child at the given position by a copy, which can be
+// modified without affecting other trees sharing the original child.
+func (n *node) copyChild(pos int) *node {
+	child := *n.children[pos]
+	n.children[pos] = &child
+	return &child
+}
+
+// route returns the path of a route registered in the subtree of the node.
+func (n *node) route() string {
+	if n.fullPath != "" {
+		return n.fullPath
+	}
+	for _, child := range n.children {
+		if path := child.route(); path != "" {
+			return path
+		}
+	}
+	return ""
+}
+
 // addRoute adds a node with the given handle to the path.
-// Not concurrency-safe!
-func (n *node) addRoute(path string, handle Handle) {
+// Nodes of the tree are copied before they are modified, so a failed
+// registration leaves no trace in other trees sharing them. Only the given
+// node itself is modified in place. The router therefore adds routes to a copy
+// of the root, while requests are served concurrently from the original.
+func (n *node) addRoute(path string, handle Handle) *RouteError {
 	fullPath := path
 	n.priority++
 	numParams := countParams(path)
 
-	// non-empty tree
-	if len(n.path) > 0 || len(n.children) > 0 {
-	walk:
-		for {
-			// Update maxParams of the current node
-			if numParams > n.maxParams {
-				n.maxParams = numParams
-			}
-
-			// Find the longest common prefix.
-			// This also implies that the common prefix contains no ':' or '*'
-			// since the existing key can't contain those chars.
-			i := 0
-			max := min(len(path), len(n.path))
-			for i < max && path[i] == n.path[i] {
-				i++
+	// Empty tree
+	if len(n.path) == 0 && len(n.children) == 0 {
+		if err := n.insertChild(numParams, path, fullPath, handle); err != nil {
+			return err
+		}
+		n.nType = root
+		return nil
+	}
+
+walk:
+	for {
+		// Update maxParams of the current node
+		if numParams > n.maxParams {
+			n.maxParams = numParams
+		}
+
+		// The children are modified below, copy them first
+		n.children = append([]*node(nil), n.children...)
+
+		// Find the longest common prefix.
+		// This also implies that the common prefix contains no ':' or '*'
+		// since the existing key can't contain those chars.
+		i := 0
+		max := min(len(path), len(n.path))
+		for i < max && path[i] == n.path[i] {
+			i++
+		}
+
+		// Split edge
+		if i < len(n.path) {
+			child := node{
+				path:      n.path[i:],
+				wildChild: n.wildChild,
+				indices:   n.indices,
+				children:  n.children,
+				handle:    n.handle,
+				priority:  n.priority - 1,
+				fullPath:  n.fullPath,
+				meta:      n.meta,
+				variants:  n.variants,
 			}
 
-			// Split edge
-			if i < len(n.path) {
-				child := node{
-					path:      n.path[i:],
-					wildChild: n.wildChild,
-					indices:   n.indices,
-					children:  n.children,
-					handle:    n.handle,
-					priority:  n.priority - 1,
+			// Update maxParams (max of all children)
+			for i := range child.children {
+				if child.children[i].maxParams > child.maxParams {
+					child.maxParams = child.children[i].maxParams
 				}
+			}
 
-				// Update maxParams (max of all children)
-				for i := range child.children {
-					if child.children[i].maxParams > child.maxParams {
-						child.maxParams = child.children[i].maxParams
-					}
-				}
+			n.children = []*node{&child}
+			// []byte for proper unicode char conversion, see #65
+			n.indices = string([]byte{n.path[i]})
+			n.path = path[:i]
+			n.handle = nil
+			n.wildChild = false
+			n.fullPath = ""
+			n.meta = nil
+			n.variants = nil
+		}
 
-				n.children = []*node{&child}
-				// []byte for proper unicode char conversion, see #65
-				n.indices = string([]byte{n.path[i]})
-				n.path = path[:i]
-				n.handle = nil
-				n.wildChild = false
+		// Make node a (in-path) leaf
+		if i == len(path) {
+			if n.handle != nil {
+				return &RouteError{
+					Path:     fullPath,
+					Conflict: n.fullPath,
+					Reason:   "a handle is already registered for this path",
+				}
+			}
+			if child := n.catchAllChild(); child != nil {
+				return &RouteError{
+					Path:     fullPath,
+					Conflict: child.fullPath,
+					Reason:   "path conflicts with existing catch-all '" + child.path + "'",
+				}
 			}
+			n.handle = handle
+			n.fullPath = fullPath
+			return nil
+		}
+
+		// Make new node a child of this node
+		path = path[i:]
+		c := path[0]
 
-			// Make new node a child of this node
-			if i < len(path) {
-				path = path[i:]
+		if c == ':' || c == '*' {
+			// the values of adjacent wildcards couldn't be told apart
+			if n.nType == param {
+				return &RouteError{
+					Path:   fullPath,
+					Reason: "wildcards must be separated by static text, has: '" + n.path + path + "'",
+				}
+			}
 
-				if n.wildChild {
-					n = n.children[0]
+			// Check if a wildcard child with the same name and constraint
+			// exists
+			end := wildcardEnd(path)
+			for i := len(n.indices); i < len(n.children); i++ {
+				if n.children[i].path == path[:end] {
+					if n.children[i].nType == catchAll && end < len(path) {
+						return &RouteError{
+							Path:   fullPath,
+							Reason: "catch-all routes are only allowed at the end of the path",
+						}
+					}
+					n = n.copyChild(i)
 					n.priority++
 
 					// Update maxParams of the child node
@@ -140,304 +339,513 @@ func (n *node) addRoute(path string, handle Handle) {
 						n.maxParams = numParams
 					}
 					numParams--
-
-					// Check if the wildcard matches
-					if len(path) >= len(n.path) && n.path == path[:len(n.path)] {
-						// check for longer wildcard, e.g. :name and :names
-						if len(n.path) >= len(path) || path[len(n.path)] == '/' {
-							continue walk
-						}
-					}
-
-					panic("path segment '" + path +
-						"' conflicts with existing wildcard '" + n.path +
-						"' in path '" + fullPath + "'")
-				}
-
-				c := path[0]
-
-				// slash after param
-				if n.nType == param && c == '/' && len(n.children) == 1 {
-					n = n.children[0]
-					n.priority++
 					continue walk
 				}
+			}
 
-				// Check if a child with the next path byte exists
-				for i := 0; i < len(n.indices); i++ {
-					if c == n.indices[i] {
-						i = n.incrementChildPrio(i)
-						n = n.children[i]
-						continue walk
-					}
-				}
+			// Otherwise insert it
+			child := &node{
+				maxParams: numParams,
+				priority:  1,
+			}
+			if err := child.insertChild(numParams, path, fullPath, handle); err != nil {
+				return err
+			}
 
-				// Otherwise insert it
-				if c != ':' && c != '*' {
-					// []byte for proper unicode char conversion, see #65
-					n.indices += string([]byte{c})
-					child := &node{
-						maxParams: numParams,
+			// check if this node has an existing wildcard which would make
+			// the lookup ambiguous if we insert the wildcard here
+			if child.check == nil {
+				if wild := n.sameWildChild(child); wild != nil {
+					return &RouteError{
+						Path:     fullPath,
+						Conflict: wild.route(),
+						Reason: "wildcard '" + path[:end] +
+							"' conflicts with existing wildcard '" + wild.path + "'",
 					}
-					n.children = append(n.children, child)
-					n.incrementChildPrio(len(n.indices) - 1)
-					n = child
 				}
-				n.insertChild(numParams, path, fullPath, handle)
-				return
-
-			} else if i == len(path) { // Make node a (in-path) leaf
-				if n.handle != nil {
-					panic("a handle is already registered for path '" + fullPath + "'")
+			}
+			if child.nType == catchAll && n.fullPath != "" {
+				return &RouteError{
+					Path:     fullPath,
+					Conflict: n.fullPath,
+					Reason:   "catch-all conflicts with existing handle for the path segment root",
 				}
-				n.handle = handle
 			}
-			return
-		}
-	} else { // Empty tree
-		n.insertChild(numParams, path, fullPath, handle)
-		n.nType = root
-	}
-}
-
-func (n *node) insertChild(numParams uint8, path, fullPath string, handle Handle) {
-	var offset int // already handled bytes of the path
 
-	// find prefix until first wildcard (beginning with ':'' or '*'')
-	for i, max := 0, len(path); numParams > 0; i++ {
-		c := path[i]
-		if c != ':' && c != '*' {
-			continue
+			n.addWildChild(child)
+			return nil
 		}
 
-		// find wildcard end (either '/' or path end)
-		end := i + 1
-		for end < max && path[end] != '/' {
-			switch path[end] {
-			// the wildcard name must not contain ':' and '*'
-			case ':', '*':
-				panic("only one wildcard per path segment is allowed, has: '" +
-					path[i:] + "' in path '" + fullPath + "'")
-			default:
-				end++
+		// Check if a child with the next path byte exists
+		for i := 0; i < len(n.indices); i++ {
+			if c == n.indices[i] {
+				n.copyChild(i)
+				i = n.incrementChildPrio(i)
+				n = n.children[i]
+				continue walk
 			}
 		}
 
-		// check if this Node existing children which would be
-		// unreachable if we insert the wildcard here
-		if len(n.children) > 0 {
-			panic("wildcard route '" + path[i:end] +
-				"' conflicts with existing children in path '" + fullPath + "'")
+		// Otherwise insert it
+		child := &node{
+			maxParams: numParams,
+		}
+		if err := child.insertChild(numParams, path, fullPath, handle); err != nil {
+			return err
 		}
+		n.incrementChildPrio(n.addStaticChild(c, child))
+		return nil
+	}
+}
 
-		// check if the wildcard has a name
-		if end-i < 2 {
-			panic("wildcards must be named with a non-empty name in path '" + fullPath + "'")
+// insertChild fills the empty node n with the given path and handle. If the
+// path contains wildcards, n gets the static prefix up to the first wildcard
+// and the remaining path is split into a chain of child nodes. A path
+// beginning with a wildcard turns n itself into a wildcard node.
+func (n *node) insertChild(numParams uint8, path, fullPath string, handle Handle) *RouteError {
+	for numParams > 0 {
+		// find prefix until first wildcard (beginning with ':' or '*')
+		i := 0
+		for path[i] != ':' && path[i] != '*' {
+			i++
 		}
 
-		if c == ':' { // param
-			// split path at the beginning of the wildcard
-			if i > 0 {
-				n.path = path[offset:i]
-				offset = i
-			}
+		// split path at the beginning of the wildcard
+		if i > 0 {
+			n.path = path[:i]
+			path = path[i:]
 
 			child := &node{
-				nType:     param,
 				maxParams: numParams,
+				priority:  1,
 			}
 			n.children = []*node{child}
 			n.wildChild = true
 			n = child
-			n.priority++
+		}
+
+		// find wildcard end
+		end := wildcardEnd(path)
+		key, expr, ok := parseWildcard(path[:end])
+
+		// the values of adjacent wildcards couldn't be told apart
+		if end < len(path) && (path[end] == ':' || path[end] == '*') {
+			return &RouteError{
+				Path:   fullPath,
+				Reason: "wildcards must be separated by static text, has: '" + path + "'",
+			}
+		}
+		if !ok {
+			return &RouteError{
+				Path:   fullPath,
+				Reason: "malformed constraint in wildcard '" + path[:end] + "'",
+			}
+		}
+
+		// check if the wildcard has a name
+		if len(key) == 0 {
+			return &RouteError{
+				Path:   fullPath,
+				Reason: "wildcards must be named with a non-empty name",
+			}
+		}
+
+		n.path = path[:end]
+		n.key = key
+		if len(expr) > 0 {
+			check, err := newConstraint(expr)
+			if err != nil {
+				return &RouteError{
+					Path:   fullPath,
+					Reason: "invalid constraint '" + expr + "': " + err.Error(),
+				}
+			}
+			n.check = check
+		}
+
+		if path[0] == ':' { // param
+			n.nType = param
 			numParams--
 
 			// if the path doesn't end with the wildcard, then there
-			// will be another non-wildcard subpath starting with '/'
-			if end < max {
-				n.path = path[offset:end]
-				offset = end
+			// will be another non-wildcard subpath, starting with '/' or
+			// with static text within the same segment
+			if end < len(path) {
+				path = path[end:]
 
 				child := &node{
 					maxParams: numParams,
 					priority:  1,
 				}
+				n.indices = string([]byte{path[0]})
 				n.children = []*node{child}
 				n = child
+				continue
 			}
 
-		} else { // catchAll
-			if end != max || numParams > 1 {
-				panic("catch-all routes are only allowed at the end of the path in path '" + fullPath + "'")
+			n.handle = handle
+			n.fullPath = fullPath
+			return nil
+		}
+
+		// catchAll
+		if end != len(path) || numParams > 1 {
+			return &RouteError{
+				Path:   fullPath,
+				Reason: "catch-all routes are only allowed at the end of the path",
 			}
+		}
 
-			if len(n.path) > 0 && n.path[len(n.path)-1] == '/' {
-				panic("catch-all conflicts with existing handle for the path segment root in path '" + fullPath + "'")
+		if len(expr) > 0 {
+			return &RouteError{
+				Path:   fullPath,
+				Reason: "catch-all routes can not have a constraint",
 			}
+		}
 
-			// currently fixed width 1 for '/'
-			i--
-			if path[i] != '/' {
-				panic("no / before catch-all in path '" + fullPath + "'")
+		// currently fixed width 1 for '/'
+		if len(fullPath) == len(path) || fullPath[len(fullPath)-len(path)-1] != '/' {
+			return &RouteError{
+				Path:   fullPath,
+				Reason: "no / before catch-all",
 			}
+		}
 
-			n.path = path[offset:i]
+		n.nType = catchAll
+		n.handle = handle
+		n.fullPath = fullPath
+		return nil
+	}
 
-			// first node: catchAll node with empty path
-			child := &node{
-				wildChild: true,
-				nType:     catchAll,
-				maxParams: 1,
+	// insert remaining path part and handle to the leaf
+	n.path = path
+	n.handle = handle
+	n.fullPath = fullPath
+	return nil
+}
+
+// findRoute returns the node holding the route registered with exactly the
+// given path, or nil if there is none.
+func (n *node) findRoute(path string) *node {
+	for n != nil {
+		var pos int
+		if pos, path = n.routeChild(path); pos < 0 {
+			if len(path) == 0 && n.fullPath != "" {
+				return n
 			}
-			n.children = []*node{child}
-			n.indices = string(path[i])
-			n = child
-			n.priority++
-
-			// second node: node holding the variable
-			child = &node{
-				path:      path[i:],
-				nType:     catchAll,
-				maxParams: 1,
-				handle:    handle,
-				priority:  1,
+			return nil
+		}
+		n = n.children[pos]
+	}
+	return nil
+}
+
+// copyRoute is like findRoute, but copies the nodes on the way to the route,
+// so it can be modified without affecting other trees sharing them. Like
+// addRoute it is called on a copy of the root.
+func (n *node) copyRoute(path string) *node {
+	for n != nil {
+		var pos int
+		if pos, path = n.routeChild(path); pos < 0 {
+			if len(path) == 0 && n.fullPath != "" {
+				return n
 			}
-			n.children = []*node{child}
+			return nil
+		}
+		n.children = append([]*node(nil), n.children...)
+		n = n.copyChild(pos)
+	}
+	return nil
+}
 
-			return
+// routeChild returns the position of the child on the way to the route
+// registered with the given path and the rest of the path for the child.
+// The position is -1 if there is no such child, the rest of the path is empty
+// if the route ends in n.
+func (n *node) routeChild(path string) (int, string) {
+	if len(path) < len(n.path) || path[:len(n.path)] != n.path {
+		return -1, path
+	}
+	path = path[len(n.path):]
+	if len(path) == 0 {
+		return -1, path
+	}
+
+	if c := path[0]; c == ':' || c == '*' {
+		end := wildcardEnd(path)
+		for i := len(n.indices); i < len(n.children); i++ {
+			if n.children[i].path == path[:end] {
+				return i, path
+			}
 		}
+		return -1, path
 	}
 
-	// insert remaining path part and handle to the leaf
-	n.path = path[offset:]
-	n.handle = handle
+	for i := 0; i < len(n.indices); i++ {
+		if path[0] == n.indices[i] {
+			return i, path
+		}
+	}
+	return -1, path
+}
+
+// walk calls fn for each node in the subtree of the node holding a route.
+func (n *node) walk(fn func(n *node)) {
+	if n.fullPath != "" {
+		fn(n)
+	}
+	for _, child := range n.children {
+		child.walk(fn)
+	}
+}
+
+// paramNames returns the names of the wildcards in the given path.
+func paramNames(path string) []string {
+	var names []string
+	for i := 0; i < len(path); i++ {
+		if c := path[i]; c == ':' || c == '*' {
+			end := i + wildcardEnd(path[i:])
+			key, _, _ := parseWildcard(path[i:end])
+			names = append(names, key)
+			i = end - 1
+		}
+	}
+	return names
+}
+
+// parseWildcard splits a wildcard like :name or :name{constraint} into its
+// name and constraint. It reports false if the constraint is malformed.
+func parseWildcard(wildcard string) (key, expr string, ok bool) {
+	key = wildcard[1:]
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		key, expr = key[:i], key[i:]
+
+		// the constraint ends with the matching closing brace, which must
+		// be the end of the wildcard
+		depth := 0
+		for j := 0; j < len(expr); j++ {
+			switch expr[j] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 && j != len(expr)-1 {
+					return key, expr, false
+				}
+			}
+		}
+		if depth != 0 || len(expr) == 2 {
+			return key, expr, false
+		}
+		return key, expr[1 : len(expr)-1], true
+	}
+	return key, "", !strings.ContainsRune(key, '}')
 }
 
-// Returns the handle registered with the given path (key). The values of
-// wildcards are saved to a map.
+// skippedNode is a branch point of the lookup. If the chosen child doesn't
+// lead to a handle, the lookup continues with the children of n from position
+// next on.
+type skippedNode struct {
+	n      *node
+	path   string
+	params int
+	next   int
+	from   int // end of the previous value tried for a param within a segment
+}
+
+// Returns the handle registered with the given path (key) and the path of
+// the route it was registered with. The values of wildcards are appended to ps,
+// which is allocated if nil and needed.
 // If no handle can be found, a TSR (trailing slash redirect) recommendation is
 // made if a handle exists with an extra (without the) trailing slash for the
 // given path.
-func (n *node) getValue(path string) (handle Handle, p Params, tsr bool) {
+// Static children are preferred over params with a constraint, those over
+// the unconstrained param and that over the catch-all. If a preferred child
+// doesn't lead to a handle, the lookup backtracks and tries the next one.
+// Branch points are only remembered for nodes which have both static and
+// wildcard children, or several wildcard children, so trees without such
+// nodes are walked as fast as before.
+// A param followed by static text within its segment, like :date in
+// /reports/:date.csv, ends at the first occurrence of the beginning of that
+// text. If the rest of the route doesn't match, the next occurrence is tried,
+// so every param gets the shortest value for which the route matches.
+func (n *node) getValue(path string, ps Params) (handle Handle, p Params, route string, tsr bool) {
+	p = ps
+	var (
+		full    = path
+		stack   [4]skippedNode
+		skipped = stack[:0]
+		next    int // child of n to resume with after backtracking
+		resume  int // end of the value of the param to resume with
+	)
+
 walk: // Outer loop for walking the tree
 	for {
-		if len(path) > len(n.path) {
-			if path[:len(n.path)] == n.path {
-				path = path[len(n.path):]
-				// If this node does not have a wildcard (param or catchAll)
-				// child,  we can just look up the next child node and continue
-				// to walk down the tree
-				if !n.wildChild {
-					c := path[0]
+		if next == 0 && resume == 0 {
+			prefix := n.path
+			if len(path) <= len(prefix) || path[:len(prefix)] != prefix {
+				if path == prefix {
+					// We should have reached the node containing the handle.
+					// Check if this node has a handle registered.
+					if handle = n.handle; handle != nil {
+						route = n.fullPath
+						return
+					}
+
+					// A catch-all child matches the directory index, too
+					if child := n.catchAllChild(); child != nil {
+						p = append(p, Param{child.key, full[len(full)-1:]})
+						handle, route = child.handle, child.fullPath
+						return
+					}
+
+					if path == "/" && n.wildChild && n.nType != root {
+						tsr = true
+						goto backtrack
+					}
+
+					// No handle found. Check if a handle for this path + a
+					// trailing slash exists for trailing slash recommendation
 					for i := 0; i < len(n.indices); i++ {
-						if c == n.indices[i] {
-							n = n.children[i]
-							continue walk
+						if n.indices[i] == '/' {
+							child := n.children[i]
+							tsr = tsr || (len(child.path) == 1 && child.handle != nil) ||
+								(child.path == "/" && child.catchAllChild() != nil)
+							break
 						}
 					}
+					goto backtrack
+				}
 
-					// Nothing found.
-					// We can recommend to redirect to the same URL without a
-					// trailing slash if a leaf exists for that path.
-					tsr = (path == "/" && n.handle != nil)
-					return
+				// Nothing found. We can recommend to redirect to the same URL
+				// with an extra trailing slash if a leaf exists for that path
+				tsr = tsr || (path == "/") ||
+					(len(prefix) == len(path)+1 && prefix[len(path)] == '/' &&
+						path == prefix[:len(prefix)-1] &&
+						(n.handle != nil || n.catchAllChild() != nil))
+				goto backtrack
+			}
+			path = path[len(prefix):]
 
+			// Look up the next static child node and continue to walk down
+			// the tree. Remember this node if it has wildcard children as
+			// well, they are tried if the static child leads nowhere.
+			c := path[0]
+			for i := 0; i < len(n.indices); i++ {
+				if c == n.indices[i] {
+					if n.wildChild {
+						skipped = append(skipped, skippedNode{n, path, len(p), len(n.indices), 0})
+					}
+					n = n.children[i]
+					continue walk
 				}
+			}
 
-				// handle wildcard child
-				n = n.children[0]
-				switch n.nType {
-				case param:
-					// find param end (either '/' or path end)
-					end := 0
-					for end < len(path) && path[end] != '/' {
-						end++
-					}
+			if !n.wildChild {
+				// Nothing found.
+				// We can recommend to redirect to the same URL without a
+				// trailing slash if a leaf exists for that path.
+				tsr = tsr || (path == "/" && n.handle != nil)
+				goto backtrack
+			}
+			next = len(n.indices)
+		}
 
-					// save param value
-					if p == nil {
-						// lazy allocation
-						p = make(Params, 0, n.maxParams)
-					}
-					i := len(p)
-					p = p[:i+1] // expand slice within preallocated capacity
-					p[i].Key = n.path[1:]
-					p[i].Value = path[:end]
-
-					// we need to go deeper!
-					if end < len(path) {
-						if len(n.children) > 0 {
-							path = path[end:]
-							n = n.children[0]
-							continue walk
+		// handle wildcard children
+		for i := next; i < len(n.children); i++ {
+			child := n.children[i]
+			switch child.nType {
+			case param:
+				from := resume
+				resume = 0
+
+				// find param end (either '/' or path end), or the next
+				// beginning of static text within the segment
+				end := 0
+				for end < len(path) && path[end] != '/' {
+					end++
+				}
+				segEnd := end
+				if child.inSegment() {
+					for k := from + 1; k < segEnd; k++ {
+						if strings.IndexByte(child.indices, path[k]) >= 0 &&
+							(child.check == nil || child.check.match(path[:k])) {
+							end = k
+							break
 						}
-
-						// ... but we can't
-						tsr = (len(path) == end+1)
-						return
 					}
+				}
 
-					if handle = n.handle; handle != nil {
-						return
-					} else if len(n.children) == 1 {
-						// No handle found. Check if a handle for this path + a
-						// trailing slash exists for TSR recommendation
-						n = n.children[0]
-						tsr = (n.path == "/" && n.handle != nil)
+				if end == segEnd && child.check != nil && !child.check.match(path[:end]) {
+					if from > 0 {
+						// the next child was remembered before
+						goto backtrack
 					}
+					continue
+				}
 
-					return
+				if from == 0 && i+1 < len(n.children) {
+					skipped = append(skipped, skippedNode{n, path, len(p), i + 1, 0})
+				}
+				if end < segEnd {
+					// try a longer value if this one leads nowhere
+					skipped = append(skipped, skippedNode{n, path, len(p), i, end})
+				}
+
+				// save param value
+				if p == nil {
+					// lazy allocation
+					p = make(Params, 0, child.maxParams)
+				}
+				p = append(p, Param{child.key, path[:end]})
 
-				case catchAll:
-					// save param value
-					if p == nil {
-						// lazy allocation
-						p = make(Params, 0, n.maxParams)
+				// we need to go deeper!
+				if end < len(path) {
+					c := path[end]
+					for i := 0; i < len(child.indices); i++ {
+						if c == child.indices[i] {
+							path = path[end:]
+							n = child.children[i]
+							next = 0
+							continue walk
+						}
 					}
-					i := len(p)
-					p = p[:i+1] // expand slice within preallocated capacity
-					p[i].Key = n.path[2:]
-					p[i].Value = path
 
-					handle = n.handle
+					// ... but we can't
+					tsr = tsr || (len(path) == end+1 && child.handle != nil)
+					goto backtrack
+				}
+
+				if handle = child.handle; handle != nil {
+					route = child.fullPath
 					return
+				}
 
-				default:
-					panic("invalid node type")
+				// No handle found. Check if a handle for this path + a
+				// trailing slash exists for TSR recommendation
+				if len(child.children) == 1 {
+					tsr = tsr || (child.children[0].path == "/" && child.children[0].handle != nil)
 				}
-			}
-		} else if path == n.path {
-			// We should have reached the node containing the handle.
-			// Check if this node has a handle registered.
-			if handle = n.handle; handle != nil {
-				return
-			}
+				goto backtrack
 
-			if path == "/" && n.wildChild && n.nType != root {
-				tsr = true
+			case catchAll:
+				// save param value, including the '/' in front of it
+				p = append(p, Param{child.key, full[len(full)-len(path)-1:]})
+				handle, route = child.handle, child.fullPath
 				return
-			}
 
-			// No handle found. Check if a handle for this path + a
-			// trailing slash exists for trailing slash recommendation
-			for i := 0; i < len(n.indices); i++ {
-				if n.indices[i] == '/' {
-					n = n.children[i]
-					tsr = (len(n.path) == 1 && n.handle != nil) ||
-						(n.nType == catchAll && n.children[0].handle != nil)
-					return
-				}
+			default:
+				panic("invalid node type")
 			}
+		}
 
+	backtrack:
+		if len(skipped) == 0 {
 			return
 		}
-
-		// Nothing found. We can recommend to redirect to the same URL with an
-		// extra trailing slash if a leaf exists for that path
-		tsr = (path == "/") ||
-			(len(n.path) == len(path)+1 && n.path[len(path)] == '/' &&
-				path == n.path[:len(n.path)-1] && n.handle != nil)
-		return
+		s := skipped[len(skipped)-1]
+		skipped = skipped[:len(skipped)-1]
+		n, path, p, next, resume = s.n, s.path, p[:s.params], s.next, s.from
 	}
 }
 
@@ -447,116 +855,135 @@ walk: // Outer loop for walking the tree
 // was successful.
 func (n *node) findCaseInsensitivePath(path string, fixTrailingSlash bool) (ciPath []byte, found bool) {
 	ciPath = make([]byte, 0, len(path)+1) // preallocate enough memory
+	return n.findCaseInsensitivePathRec(path, ciPath, fixTrailingSlash)
+}
+
+// recursive case-insensitive lookup function used by n.findCaseInsensitivePath
+func (n *node) findCaseInsensitivePathRec(path string, ciPath []byte, fixTrailingSlash bool) ([]byte, bool) {
+	if len(path) < len(n.path) || strings.ToLower(path[:len(n.path)]) != strings.ToLower(n.path) {
+		// Nothing found.
+		// Try to fix the path by adding / removing a trailing slash
+		if fixTrailingSlash {
+			if path == "/" {
+				return ciPath, true
+			}
+			if len(path)+1 == len(n.path) && n.path[len(path)] == '/' &&
+				strings.ToLower(path) == strings.ToLower(n.path[:len(path)]) &&
+				(n.handle != nil || n.catchAllChild() != nil) {
+				return append(ciPath, n.path...), true
+			}
+		}
+		return ciPath, false
+	}
 
-	// Outer loop for walking the tree
-	for len(path) >= len(n.path) && strings.ToLower(path[:len(n.path)]) == strings.ToLower(n.path) {
-		path = path[len(n.path):]
-		ciPath = append(ciPath, n.path...)
+	path = path[len(n.path):]
+	ciPath = append(ciPath, n.path...)
 
-		if len(path) > 0 {
-			// If this node does not have a wildcard (param or catchAll) child,
-			// we can just look up the next child node and continue to walk down
-			// the tree
-			if !n.wildChild {
-				r := unicode.ToLower(rune(path[0]))
-				for i, index := range n.indices {
-					// must use recursive approach since both index and
-					// ToLower(index) could exist. We must check both.
-					if r == unicode.ToLower(index) {
-						out, found := n.children[i].findCaseInsensitivePath(path, fixTrailingSlash)
-						if found {
-							return append(ciPath, out...), true
-						}
+	if len(path) == 0 {
+		// We should have reached the node containing the handle.
+		// Check if this node has a handle registered.
+		if n.handle != nil || n.catchAllChild() != nil {
+			return ciPath, true
+		}
+
+		// No handle found.
+		// Try to fix the path by adding a trailing slash
+		if fixTrailingSlash {
+			for i := 0; i < len(n.indices); i++ {
+				if n.indices[i] == '/' {
+					child := n.children[i]
+					if len(child.path) == 1 &&
+						(child.handle != nil || child.catchAllChild() != nil) {
+						return append(ciPath, '/'), true
 					}
+					break
 				}
-
-				// Nothing found. We can recommend to redirect to the same URL
-				// without a trailing slash if a leaf exists for that path
-				found = (fixTrailingSlash && path == "/" && n.handle != nil)
-				return
 			}
+		}
+		return ciPath, false
+	}
 
-			n = n.children[0]
-			switch n.nType {
-			case param:
-				// find param end (either '/' or path end)
-				k := 0
-				for k < len(path) && path[k] != '/' {
-					k++
-				}
+	// Static children first. Must use recursive approach since both index
+	// and ToLower(index) could exist. We must check both.
+	r := unicode.ToLower(rune(path[0]))
+	for i, index := range n.indices {
+		if r == unicode.ToLower(index) {
+			if out, found := n.children[i].findCaseInsensitivePathRec(path, ciPath, fixTrailingSlash); found {
+				return out, true
+			}
+		}
+	}
 
-				// add param value to case insensitive path
-				ciPath = append(ciPath, path[:k]...)
+	for _, child := range n.children[len(n.indices):] {
+		switch child.nType {
+		case param:
+			// find param end (either '/' or path end)
+			k := 0
+			for k < len(path) && path[k] != '/' {
+				k++
+			}
 
-				// we need to go deeper!
-				if k < len(path) {
-					if len(n.children) > 0 {
-						path = path[k:]
-						n = n.children[0]
+			// try the values followed by static text within the segment
+			if child.inSegment() {
+				for end := 1; end < k; end++ {
+					if child.check != nil && !child.check.match(path[:end]) {
 						continue
 					}
-
-					// ... but we can't
-					if fixTrailingSlash && len(path) == k+1 {
-						return ciPath, true
+					r := unicode.ToLower(rune(path[end]))
+					for i, index := range child.indices {
+						if index != '/' && r == unicode.ToLower(index) {
+							out := append(ciPath, path[:end]...)
+							if out, found := child.children[i].findCaseInsensitivePathRec(path[end:], out, fixTrailingSlash); found {
+								return out, true
+							}
+						}
 					}
-					return
 				}
+			}
 
-				if n.handle != nil {
-					return ciPath, true
-				} else if fixTrailingSlash && len(n.children) == 1 {
-					// No handle found. Check if a handle for this path + a
-					// trailing slash exists
-					n = n.children[0]
-					if n.path == "/" && n.handle != nil {
-						return append(ciPath, '/'), true
+			if child.check != nil && !child.check.match(path[:k]) {
+				continue
+			}
+
+			// add param value to case insensitive path
+			out := append(ciPath, path[:k]...)
+
+			// we need to go deeper!
+			if k < len(path) {
+				for i := 0; i < len(child.indices); i++ {
+					if child.indices[i] == path[k] {
+						if out, found := child.children[i].findCaseInsensitivePathRec(path[k:], out, fixTrailingSlash); found {
+							return out, true
+						}
 					}
 				}
-				return
 
-			case catchAll:
-				return append(ciPath, path...), true
-
-			default:
-				panic("invalid node type")
-			}
-		} else {
-			// We should have reached the node containing the handle.
-			// Check if this node has a handle registered.
-			if n.handle != nil {
-				return ciPath, true
+				// ... but we can't
+				if fixTrailingSlash && len(path) == k+1 {
+					return out, true
+				}
+				continue
 			}
 
-			// No handle found.
-			// Try to fix the path by adding a trailing slash
-			if fixTrailingSlash {
-				for i := 0; i < len(n.indices); i++ {
-					if n.indices[i] == '/' {
-						n = n.children[i]
-						if (len(n.path) == 1 && n.handle != nil) ||
-							(n.nType == catchAll && n.children[0].handle != nil) {
-							return append(ciPath, '/'), true
-						}
-						return
-					}
+			if child.handle != nil {
+				return out, true
+			} else if fixTrailingSlash && len(child.children) == 1 {
+				// No handle found. Check if a handle for this path + a
+				// trailing slash exists
+				if child.children[0].path == "/" && child.children[0].handle != nil {
+					return append(out, '/'), true
 				}
 			}
-			return
-		}
-	}
 
-	// Nothing found.
-	// Try to fix the path by adding / removing a trailing slash
-	if fixTrailingSlash {
-		if path == "/" {
-			return ciPath, true
-		}
-		if len(path)+1 == len(n.path) && n.path[len(path)] == '/' &&
-			strings.ToLower(path) == strings.ToLower(n.path[:len(path)]) &&
-			n.handle != nil {
-			return append(ciPath, n.path...), true
+		case catchAll:
+			return append(ciPath, path...), true
+
+		default:
+			panic("invalid node type")
 		}
 	}
-	return
+
+	// Nothing found. We can recommend to redirect to the same URL
+	// without a trailing slash if a leaf exists for that path
+	return ciPath, fixTrailingSlash && path == "/" && n.handle != nil
 }
@@ -40,7 +40,7 @@ type testRequests []struct {
 
 func checkRequests(t *testing.T, tree *node, requests testRequests) {
 	for _, request := range requests {
-		handler, ps, _ := tree.getValue(request.path)
+		handler, ps, route, _ := tree.getValue(request.path, nil)
 
 		if handler == nil {
 			if !request.nilHandler {
@@ -53,6 +53,9 @@ func checkRequests(t *testing.T, tree *node, requests testRequests) {
 			if fakeHandlerValue != request.route {
 				t.Errorf("handle mismatch for route '%s': Wrong handle (%s != %s)", request.path, fakeHandlerValue, request.route)
 			}
+			if route != request.route {
+				t.Errorf("route mismatch for route '%s': Wrong route (%s != %s)", request.path, route, request.route)
+			}
 		}
 
 		if !reflect.DeepEqual(ps, request.ps) {
@@ -110,6 +113,9 @@ func TestCountParams(t *testing.T) {
 	if countParams(strings.Repeat("/:param", 256)) != 255 {
 		t.Fail()
 	}
+	if countParams("/:param{[:*]+}/*catch-all") != 2 {
+		t.Fail()
+	}
 }
 
 func TestTreeAddAndGet(t *testing.T) {
@@ -198,6 +204,110 @@ func TestTreeWildcard(t *testing.T) {
 	checkMaxParams(t, tree)
 }
 
+func TestTreeParamConstraint(t *testing.T) {
+	tree := &node{}
+
+	routes := [...]string{
+		"/users/new",
+		"/users/:id{int}",
+		"/users/:id{int}/posts",
+		"/users/:name{[a-z]+}",
+		"/users/:name{[a-z]+}/about",
+		"/files/:key{uuid}",
+		"/files/:key{uuid}/:rev{uint}",
+		"/codes/:code{[A-Z]{2}}",
+	}
+	for _, route := range routes {
+		if err := tree.addRoute(route, fakeHandler(route)); err != nil {
+			t.Fatalf("error inserting route '%s': %v", route, err)
+		}
+	}
+
+	//printChildren(tree, "")
+
+	checkRequests(t, tree, testRequests{
+		{"/users/new", false, "/users/new", nil},
+		{"/users/42", false, "/users/:id{int}", Params{Param{"id", "42"}}},
+		{"/users/-42", false, "/users/:id{int}", Params{Param{"id", "-42"}}},
+		{"/users/42/posts", false, "/users/:id{int}/posts", Params{Param{"id", "42"}}},
+		{"/users/newer", false, "/users/:name{[a-z]+}", Params{Param{"name", "newer"}}},
+		{"/users/gopher/about", false, "/users/:name{[a-z]+}/about", Params{Param{"name", "gopher"}}},
+		{"/users/Gopher", true, "", nil},
+		{"/users/4a", true, "", nil},
+		{"/files/6ba7b810-9dad-11d1-80b4-00c04fd430c8", false, "/files/:key{uuid}", Params{Param{"key", "6ba7b810-9dad-11d1-80b4-00c04fd430c8"}}},
+		{"/files/6ba7b810-9dad-11d1-80b4-00c04fd430c8/3", false, "/files/:key{uuid}/:rev{uint}", Params{Param{"key", "6ba7b810-9dad-11d1-80b4-00c04fd430c8"}, Param{"rev", "3"}}},
+		{"/files/6ba7b810-9dad-11d1-80b4", true, "", nil},
+		{"/codes/DE", false, "/codes/:code{[A-Z]{2}}", Params{Param{"code", "DE"}}},
+		{"/codes/DEU", true, "", nil},
+	})
+
+	checkPriorities(t, tree)
+	checkMaxParams(t, tree)
+}
+
+func TestTreeParamsInSegment(t *testing.T) {
+	tree := &node{}
+
+	routes := [...]string{
+		"/files/:name",
+		"/files/:name.:ext",
+		"/files/:name.:ext/meta",
+		"/reports/:date.csv",
+		"/reports/:date.json",
+		"/v:version/users",
+		"/range/:from-:to",
+		"/img/:w{int}x:h{int}.png",
+		"/img/:name.png",
+	}
+	for _, route := range routes {
+		if err := tree.addRoute(route, fakeHandler(route)); err != nil {
+			t.Fatalf("error inserting route '%s': %v", route, err)
+		}
+	}
+
+	//printChildren(tree, "")
+
+	checkRequests(t, tree, testRequests{
+		{"/files/readme", false, "/files/:name", Params{Param{"name", "readme"}}},
+		{"/files/app.tar.gz", false, "/files/:name.:ext", Params{Param{"name", "app"}, Param{"ext", "tar.gz"}}},
+		{"/files/app.zip/meta", false, "/files/:name.:ext/meta", Params{Param{"name", "app"}, Param{"ext", "zip"}}},
+		{"/reports/2020.csv", false, "/reports/:date.csv", Params{Param{"date", "2020"}}},
+		{"/reports/2020.01.csv", false, "/reports/:date.csv", Params{Param{"date", "2020.01"}}},
+		{"/reports/2020.01.json", false, "/reports/:date.json", Params{Param{"date", "2020.01"}}},
+		{"/reports/2020.xml", true, "", Params{Param{"date", "2020.xml"}}},
+		{"/reports/.csv", true, "", Params{Param{"date", ".csv"}}},
+		{"/v2/users", false, "/v:version/users", Params{Param{"version", "2"}}},
+		{"/range/1-10", false, "/range/:from-:to", Params{Param{"from", "1"}, Param{"to", "10"}}},
+		{"/range/1-", true, "", Params{Param{"from", "1-"}}},
+		{"/img/100x200.png", false, "/img/:w{int}x:h{int}.png", Params{Param{"w", "100"}, Param{"h", "200"}}},
+		{"/img/box.png", false, "/img/:name.png", Params{Param{"name", "box"}}},
+		{"/img/100x200.jpg", true, "", Params{Param{"name", "100x200.jpg"}}},
+	})
+
+	checkPriorities(t, tree)
+	checkMaxParams(t, tree)
+
+	out, found := tree.findCaseInsensitivePath("/REPORTS/2020.01.CSV", false)
+	if !found || string(out) != "/reports/2020.01.csv" {
+		t.Errorf("wrong case-insensitive result: %q, %t", out, found)
+	}
+}
+
+func TestTreeParamsInSegmentConflict(t *testing.T) {
+	routes := []testRoute{
+		{"/files/:name.:ext", false},
+		{"/files/:name.txt", false},
+		{"/files/:file.txt", true},
+		{"/files/:name-:size", false},
+		{"/files/:name:ext", true},
+		{"/files/:name.*ext", true},
+		{"/v:version", false},
+		{"/v:major.:minor", true},
+		{"/v:version.:minor", false},
+	}
+	testRoutes(t, routes)
+}
+
 func catchPanic(testFunc func()) (recv interface{}) {
 	defer func() {
 		recv = recover()
@@ -216,16 +326,14 @@ func testRoutes(t *testing.T, routes []testRoute) {
 	tree := &node{}
 
 	for _, route := range routes {
-		recv := catchPanic(func() {
-			tree.addRoute(route.path, nil)
-		})
+		err := tree.addRoute(route.path, nil)
 
 		if route.conflict {
-			if recv == nil {
-				t.Errorf("no panic for conflicting route '%s'", route.path)
+			if err == nil {
+				t.Errorf("no error for conflicting route '%s'", route.path)
 			}
-		} else if recv != nil {
-			t.Errorf("unexpected panic for route '%s': %v", route.path, recv)
+		} else if err != nil {
+			t.Errorf("unexpected error for route '%s': %v", route.path, err)
 		}
 	}
 
@@ -235,20 +343,25 @@ func testRoutes(t *testing.T, routes []testRoute) {
 func TestTreeWildcardConflict(t *testing.T) {
 	routes := []testRoute{
 		{"/cmd/:tool/:sub", false},
-		{"/cmd/vet", true},
+		{"/cmd/vet", false},
+		{"/cmd/:tool/vet", false},
+		{"/cmd/:command", true},
 		{"/src/*filepath", false},
 		{"/src/*filepathx", true},
+		{"/src/*filepath/x", true},
 		{"/src/", true},
+		{"/src/:file", false},
 		{"/src1/", false},
 		{"/src1/*filepath", true},
 		{"/src2*filepath", true},
 		{"/search/:query", false},
-		{"/search/invalid", true},
+		{"/search/invalid", false},
+		{"/search/:id", true},
 		{"/user_:name", false},
-		{"/user_x", true},
+		{"/user_x", false},
 		{"/user_:name", false},
 		{"/id:id", false},
-		{"/id/:id", true},
+		{"/id/:id", false},
 	}
 	testRoutes(t, routes)
 }
@@ -256,19 +369,127 @@ func TestTreeWildcardConflict(t *testing.T) {
 func TestTreeChildConflict(t *testing.T) {
 	routes := []testRoute{
 		{"/cmd/vet", false},
-		{"/cmd/:tool/:sub", true},
+		{"/cmd/:tool/:sub", false},
 		{"/src/AUTHORS", false},
-		{"/src/*filepath", true},
+		{"/src/*filepath", false},
 		{"/user_x", false},
-		{"/user_:name", true},
+		{"/user_:name", false},
 		{"/id/:id", false},
-		{"/id:id", true},
-		{"/:id", true},
-		{"/*filepath", true},
+		{"/id:id", false},
+		{"/:id", false},
+		{"/*filepath", false},
+	}
+	testRoutes(t, routes)
+}
+
+func TestTreeStaticWildcardPriority(t *testing.T) {
+	tree := &node{}
+
+	routes := [...]string{
+		"/users/new",
+		"/users/new/edit",
+		"/users/:id",
+		"/users/:id/posts",
+		"/users/:id{int}",
+		"/files/readme",
+		"/files/:name",
+		"/files/:name/meta",
+		"/files/*filepath",
+		"/:page",
+		"/about",
+	}
+	for _, route := range routes {
+		if err := tree.addRoute(route, fakeHandler(route)); err != nil {
+			t.Fatalf("error inserting route '%s': %v", route, err)
+		}
+	}
+
+	//printChildren(tree, "")
+
+	checkRequests(t, tree, testRequests{
+		{"/users/new", false, "/users/new", nil},
+		{"/users/new/edit", false, "/users/new/edit", nil},
+		{"/users/newest", false, "/users/:id", Params{Param{"id", "newest"}}},
+		{"/users/new/posts", false, "/users/:id/posts", Params{Param{"id", "new"}}},
+		{"/users/42", false, "/users/:id{int}", Params{Param{"id", "42"}}},
+		{"/users/42/posts", false, "/users/:id/posts", Params{Param{"id", "42"}}},
+		{"/files/", false, "/files/*filepath", Params{Param{"filepath", "/"}}},
+		{"/files/readme", false, "/files/readme", nil},
+		{"/files/license", false, "/files/:name", Params{Param{"name", "license"}}},
+		{"/files/license/meta", false, "/files/:name/meta", Params{Param{"name", "license"}}},
+		{"/files/license/text", false, "/files/*filepath", Params{Param{"filepath", "/license/text"}}},
+		{"/files/readme/meta", false, "/files/:name/meta", Params{Param{"name", "readme"}}},
+		{"/about", false, "/about", nil},
+		{"/contact", false, "/:page", Params{Param{"page", "contact"}}},
+		{"/users", false, "/:page", Params{Param{"page", "users"}}},
+	})
+
+	checkPriorities(t, tree)
+	checkMaxParams(t, tree)
+}
+
+func TestTreeConstraintConflict(t *testing.T) {
+	routes := []testRoute{
+		{"/users/:id{int}", false},
+		{"/users/new", false},
+		{"/users/:name{[a-z]+}", false},
+		{"/users/:id{int}", false},
+		{"/users/:user", false},
+		{"/users/:other", true},
+		{"/users/*path", false},
+		{"/bad/:id{", true},
+		{"/bad/:id{}", true},
+		{"/bad/:id{int}:x", true},
+		{"/bad/:id{[a-z}", true},
+		{"/bad/*path{int}", true},
 	}
 	testRoutes(t, routes)
 }
 
+func TestTreeConflictError(t *testing.T) {
+	tree := &node{}
+
+	routes := [...]string{
+		"/cmd/:tool/:sub",
+		"/src/*filepath",
+		"/doc/",
+	}
+	for _, route := range routes {
+		if err := tree.addRoute(route, fakeHandler(route)); err != nil {
+			t.Fatalf("error inserting route '%s': %v", route, err)
+		}
+	}
+
+	tests := []struct {
+		path     string
+		conflict string
+	}{
+		{"/cmd/:command", "/cmd/:tool/:sub"},
+		{"/src/*path", "/src/*filepath"},
+		{"/src/", "/src/*filepath"},
+		{"/doc/*filepath", "/doc/"},
+		{"/doc/", "/doc/"},
+	}
+	for _, test := range tests {
+		err := tree.addRoute(test.path, fakeHandler(test.path))
+		if err == nil {
+			t.Errorf("no error for conflicting route '%s'", test.path)
+			continue
+		}
+		if err.Path != test.path || err.Conflict != test.conflict {
+			t.Errorf("wrong error for route '%s': got path '%s', conflict '%s'; want conflict '%s'",
+				test.path, err.Path, err.Conflict, test.conflict)
+		}
+	}
+
+	// the tree must not be changed by the failed registrations
+	checkRequests(t, tree, testRequests{
+		{"/cmd/test/3", false, "/cmd/:tool/:sub", Params{Param{"tool", "test"}, Param{"sub", "3"}}},
+		{"/src/some/file.png", false, "/src/*filepath", Params{Param{"filepath", "/some/file.png"}}},
+		{"/doc/", false, "/doc/", nil},
+	})
+}
+
 func TestTreeDupliatePath(t *testing.T) {
 	tree := &node{}
 
@@ -280,19 +501,13 @@ func TestTreeDupliatePath(t *testing.T) {
 		"/user_:name",
 	}
 	for _, route := range routes {
-		recv := catchPanic(func() {
-			tree.addRoute(route, fakeHandler(route))
-		})
-		if recv != nil {
-			t.Fatalf("panic inserting route '%s': %v", route, recv)
+		if err := tree.addRoute(route, fakeHandler(route)); err != nil {
+			t.Fatalf("error inserting route '%s': %v", route, err)
 		}
 
 		// Add again
-		recv = catchPanic(func() {
-			tree.addRoute(route, nil)
-		})
-		if recv == nil {
-			t.Fatalf("no panic while inserting duplicate route '%s", route)
+		if err := tree.addRoute(route, nil); err == nil {
+			t.Fatalf("no error while inserting duplicate route '%s", route)
 		}
 	}
 
@@ -317,11 +532,8 @@ func TestEmptyWildcardName(t *testing.T) {
 		"/src/*",
 	}
 	for _, route := range routes {
-		recv := catchPanic(func() {
-			tree.addRoute(route, nil)
-		})
-		if recv == nil {
-			t.Fatalf("no panic while inserting route with empty wildcard name '%s", route)
+		if err := tree.addRoute(route, nil); err == nil {
+			t.Fatalf("no error while inserting route with empty wildcard name '%s", route)
 		}
 	}
 }
@@ -344,7 +556,7 @@ func TestTreeCatchAllConflictRoot(t *testing.T) {
 }
 
 func TestTreeDoubleWildcard(t *testing.T) {
-	const panicMsg = "only one wildcard per path segment is allowed"
+	const errMsg = "wildcards must be separated by static text"
 
 	routes := [...]string{
 		"/:foo:bar",
@@ -354,12 +566,10 @@ func TestTreeDoubleWildcard(t *testing.T) {
 
 	for _, route := range routes {
 		tree := &node{}
-		recv := catchPanic(func() {
-			tree.addRoute(route, nil)
-		})
+		err := tree.addRoute(route, nil)
 
-		if rs, ok := recv.(string); !ok || !strings.HasPrefix(rs, panicMsg) {
-			t.Fatalf(`"Expected panic "%s" for route '%s', got "%v"`, panicMsg, route, recv)
+		if err == nil || !strings.HasPrefix(err.Reason, errMsg) {
+			t.Fatalf(`"Expected error "%s" for route '%s', got "%v"`, errMsg, route, err)
 		}
 	}
 }
@@ -405,11 +615,8 @@ func TestTreeTrailingSlashRedirect(t *testing.T) {
 		"/api/hello/:name",
 	}
 	for _, route := range routes {
-		recv := catchPanic(func() {
-			tree.addRoute(route, fakeHandler(route))
-		})
-		if recv != nil {
-			t.Fatalf("panic inserting route '%s': %v", route, recv)
+		if err := tree.addRoute(route, fakeHandler(route)); err != nil {
+			t.Fatalf("error inserting route '%s': %v", route, err)
 		}
 	}
 
@@ -432,7 +639,7 @@ func TestTreeTrailingSlashRedirect(t *testing.T) {
 		"/doc/",
 	}
 	for _, route := range tsrRoutes {
-		handler, _, tsr := tree.getValue(route)
+		handler, _, _, tsr := tree.getValue(route, nil)
 		if handler != nil {
 			t.Fatalf("non-nil handler for TSR route '%s", route)
 		} else if !tsr {
@@ -449,7 +656,7 @@ func TestTreeTrailingSlashRedirect(t *testing.T) {
 		"/api/world/abc",
 	}
 	for _, route := range noTsrRoutes {
-		handler, _, tsr := tree.getValue(route)
+		handler, _, _, tsr := tree.getValue(route, nil)
 		if handler != nil {
 			t.Fatalf("non-nil handler for No-TSR route '%s", route)
 		} else if tsr {
@@ -461,14 +668,11 @@ func TestTreeTrailingSlashRedirect(t *testing.T) {
 func TestTreeRootTrailingSlashRedirect(t *testing.T) {
 	tree := &node{}
 
-	recv := catchPanic(func() {
-		tree.addRoute("/:test", fakeHandler("/:test"))
-	})
-	if recv != nil {
-		t.Fatalf("panic inserting test route: %v", recv)
+	if err := tree.addRoute("/:test", fakeHandler("/:test")); err != nil {
+		t.Fatalf("error inserting test route: %v", err)
 	}
 
-	handler, _, tsr := tree.getValue("/")
+	handler, _, _, tsr := tree.getValue("/", nil)
 	if handler != nil {
 		t.Fatalf("non-nil handler")
 	} else if tsr {
@@ -505,11 +709,8 @@ func TestTreeFindCaseInsensitivePath(t *testing.T) {
 	}
 
 	for _, route := range routes {
-		recv := catchPanic(func() {
-			tree.addRoute(route, fakeHandler(route))
-		})
-		if recv != nil {
-			t.Fatalf("panic inserting route '%s': %v", route, recv)
+		if err := tree.addRoute(route, fakeHandler(route)); err != nil {
+			t.Fatalf("error inserting route '%s': %v", route, err)
 		}
 	}
 
@@ -619,7 +820,7 @@ func TestTreeInvalidNodeType(t *testing.T) {
 
 	// normal lookup
 	recv := catchPanic(func() {
-		tree.getValue("/test")
+		tree.getValue("/test", nil)
 	})
 	if rs, ok := recv.(string); !ok || rs != panicMsg {
 		t.Fatalf("Expected panic '"+panicMsg+"', got '%v'", recv)
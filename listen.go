@@ -0,0 +1,115 @@
+package cobalt
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation.
+const listenFDsStart = 3
+
+// Listener is a listener served by ServeListeners with its own server.
+type Listener struct {
+	Listener net.Listener
+	// Server configures the timeouts and header limits of the server of the listener, its address is
+	// ignored. It defaults to a server without limits.
+	Server *http.Server
+	// Handler serves the requests of the listener, e.g. admin endpoints. It overrides the handler of
+	// Server and defaults to the app.
+	Handler http.Handler
+	// TLS serves TLS on the listener with the configuration, see ServeTLS.
+	TLS *TLSConfig
+}
+
+// ServeListeners serves the listeners until the app is shut down, like Serve. All of them are shut
+// down together, by a signal or by Shutdown. If one of them fails, the others are shut down and its
+// error is returned.
+func (c *Cobalt) ServeListeners(listeners ...Listener) error {
+	if len(listeners) == 0 {
+		return errors.New("no listeners to serve")
+	}
+
+	runs := make([]serverRun, len(listeners))
+	for i, l := range listeners {
+		srv := l.Server
+		if srv == nil {
+			srv = &http.Server{}
+		}
+		if l.Handler != nil {
+			srv.Handler = l.Handler
+		}
+		ln := l.Listener
+
+		runs[i] = serverRun{srv, func() error { return srv.Serve(ln) }}
+		if l.TLS != nil {
+			config, err := l.TLS.build()
+			if err != nil {
+				return fmt.Errorf("listener %s: %v", ln.Addr(), err)
+			}
+			srv.TLSConfig = config
+			runs[i].listen = func() error { return srv.ServeTLS(ln, "", "") }
+		}
+	}
+	return c.serveWith(runs...)
+}
+
+// ListenUnix listens on the Unix domain socket at the path. A socket left at the path, e.g. by a
+// crashed process, is removed first. The socket is removed when the listener is closed.
+func ListenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// InheritedListeners returns the listeners passed by systemd socket activation, see sd_listen_fds,
+// in the order of the sockets of the unit, together with their names, which are "unknown" unless
+// set by FileDescriptorName. It returns no listeners if the process wasn't socket activated. The
+// environment variables of the activation are unset, so child processes don't inherit them.
+func InheritedListeners() ([]net.Listener, []string, error) {
+	return inheritedListeners(listenFDsStart)
+}
+
+// inheritedListeners returns the listeners passed from the file descriptor start on.
+func inheritedListeners(start int) ([]net.Listener, []string, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	if len(names) != n {
+		names = make([]string, n)
+		for i := range names {
+			names[i] = "unknown"
+		}
+	}
+
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		f := os.NewFile(uintptr(start+i), names[i])
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, nil, fmt.Errorf("inherited file descriptor %d (%s): %v", start+i, names[i], err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, names, nil
+}
@@ -0,0 +1,117 @@
+package cobalt
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// listen listens on a free local TCP address.
+func listen(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// get makes a request by the client and returns the response body.
+func get(t *testing.T, client *http.Client, url string) string {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+// TestServeListeners tests serving several listeners with a shared shutdown.
+func TestServeListeners(t *testing.T) {
+	dir, err := os.MkdirTemp("", "cobalt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "app.sock")
+	// a stale socket is replaced
+	stale, err := ListenUnix(socket)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	unix, err := ListenUnix(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(&JSONEncoder{})
+	c.Signals()
+	c.Get("/", func(ctx *Context) {
+		ctx.Response.Write([]byte("app"))
+	})
+	admin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("admin"))
+	})
+
+	main, adm := listen(t), listen(t)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.ServeListeners(
+			Listener{Listener: main},
+			Listener{Listener: adm, Handler: admin},
+			Listener{Listener: unix, Server: &http.Server{}},
+		)
+	}()
+
+	if body := get(t, http.DefaultClient, "http://"+main.Addr().String()+"/"); body != "app" {
+		t.Errorf("expected the app instead got %q", body)
+	}
+	if body := get(t, http.DefaultClient, "http://"+adm.Addr().String()+"/"); body != "admin" {
+		t.Errorf("expected the admin handler instead got %q", body)
+	}
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	if body := get(t, unixClient, "http://app/"); body != "app" {
+		t.Errorf("expected the app on the unix socket instead got %q", body)
+	}
+
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("expected ServeListeners to return nil instead got %v", err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed instead got %v", err)
+	}
+}
+
+// TestServeListenersFailure tests shutting down all listeners if one fails.
+func TestServeListenersFailure(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.Signals()
+	var hooked bool
+	c.OnShutdown(func(context.Context) error { hooked = true; return nil })
+
+	closed := listen(t)
+	closed.Close()
+	if err := c.ServeListeners(Listener{Listener: listen(t)}, Listener{Listener: closed}); err == nil {
+		t.Error("expected the error of the closed listener")
+	}
+	if !hooked {
+		t.Error("expected the app to be shut down")
+	}
+
+	if err := New(&JSONEncoder{}).ServeListeners(); err == nil {
+		t.Error("expected an error without listeners")
+	}
+}
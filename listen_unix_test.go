@@ -0,0 +1,48 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package cobalt
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// TestInheritedListeners tests taking over listeners passed by socket activation.
+func TestInheritedListeners(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// the descriptor is taken over and closed, so pass one f doesn't own
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listeners, _, err := inheritedListeners(fd)
+	if err != nil || listeners != nil {
+		t.Fatalf("expected no listeners without socket activation instead got %v %v", listeners, err)
+	}
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "1")
+	os.Setenv("LISTEN_FDNAMES", "admin")
+	listeners, names, err := inheritedListeners(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 || names[0] != "admin" || listeners[0].Addr().String() != l.Addr().String() {
+		t.Fatalf("expected the listener admin on %s instead got %v %v", l.Addr(), listeners, names)
+	}
+	listeners[0].Close()
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("expected the environment to be unset")
+	}
+}
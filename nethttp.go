@@ -0,0 +1,112 @@
+package cobalt
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// contextKey is the key under which the *Context of a request is stored in the context of the
+// request handed to net/http handlers and middleware.
+type contextKey struct{}
+
+// withContext returns a shallow copy of the request which carries the cobalt context.
+func withContext(req *http.Request, ctx *Context) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), contextKey{}, ctx))
+}
+
+// fromRequest returns the cobalt context carried by the request, if any.
+func fromRequest(req *http.Request) *Context {
+	ctx, _ := req.Context().Value(contextKey{}).(*Context)
+	return ctx
+}
+
+// RequestID returns the id of the cobalt request a context belongs to, e.g. the context of the
+// request passed to a mounted http.Handler or a net/http middleware. It is the id sent in the
+// X-Request-Id header. It returns an empty string if the context doesn't belong to a cobalt request.
+func RequestID(ctx context.Context) string {
+	if c, ok := ctx.Value(contextKey{}).(*Context); ok {
+		return c.ID
+	}
+	return ""
+}
+
+// GetData returns the value for the specified key from the data of the cobalt request a context belongs
+// to, see Context.GetData. It returns nil if the context doesn't belong to a cobalt request.
+func GetData(ctx context.Context, key string) interface{} {
+	if c, ok := ctx.Value(contextKey{}).(*Context); ok {
+		return c.GetData(key)
+	}
+	return nil
+}
+
+// SetData sets the data for the specified key in the cobalt request a context belongs to, e.g. for a
+// net/http middleware to pass data to the cobalt handlers after it, see Context.SetData. It reports
+// whether the context belongs to a cobalt request.
+func SetData(ctx context.Context, key string, value interface{}) bool {
+	if c, ok := ctx.Value(contextKey{}).(*Context); ok {
+		c.SetData(key, value)
+		return true
+	}
+	return false
+}
+
+// HTTPMiddleWare adapts a net/http middleware, e.g. for gzip, authentication or tracing, to a
+// MiddleWare. The middleware gets the request and the response writer of the context, if it passes
+// on others, e.g. a compressing writer, the handlers after it see those. Once the middleware returns,
+// the context has its own request and response writer again. The cobalt context is available to the
+// middleware through the context of the request, see RequestID, GetData and SetData.
+// A middleware may return before the handlers after it do, e.g. http.TimeoutHandler, which runs them
+// in another go routine. The context is then left to those handlers and isn't reused, the handlers
+// before the middleware must not use it anymore. If the handlers are called after the middleware
+// returned, they are skipped.
+func HTTPMiddleWare(mw func(http.Handler) http.Handler) MiddleWare {
+	return func(h Handler) Handler {
+		return func(ctx *Context) {
+			var (
+				mu                sync.Mutex
+				running, returned bool
+			)
+			resp, req := ctx.Response, ctx.Request
+			defer func() {
+				mu.Lock()
+				defer mu.Unlock()
+				returned = true
+				if running {
+					ctx.detached = true
+					return
+				}
+				ctx.Response, ctx.Request = resp, req
+			}()
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				mu.Lock()
+				if returned {
+					mu.Unlock()
+					return
+				}
+				running = true
+				mu.Unlock()
+				defer func() {
+					mu.Lock()
+					running = false
+					mu.Unlock()
+				}()
+
+				ctx.Response, ctx.Request = w, req
+				h(ctx)
+			})
+			mw(next).ServeHTTP(ctx.Response, withContext(ctx.Request, ctx))
+		}
+	}
+}
+
+// HTTPHandler exposes a cobalt handler as http.Handler, e.g. to serve it by a net/http mux or to pass
+// it to a net/http middleware. The requests are served with the global and the given middleware and
+// the panic handling of the app. If the request was passed on by a cobalt app, e.g. through Mount or
+// HTTPMiddleWare, the handler gets the request id and a copy of the data of that request.
+func (c *Cobalt) HTTPHandler(h Handler, m ...MiddleWare) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.serve(w, req, nil, "", h, m)
+	})
+}
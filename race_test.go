@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package cobalt
+
+// sync.Pool drops items at random with the race detector, so the counted allocations are not
+// meaningful then.
+const raceEnabled = true
@@ -0,0 +1,199 @@
+package cobalt
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultDrainTimeout is the time given to active requests to finish after a shutdown signal.
+const defaultDrainTimeout = 30 * time.Second
+
+// defaultSignals are the signals handled by Serve unless set by Signals.
+var defaultSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// lifecycle tracks the servers started by Serve and the shutdown of the app.
+type lifecycle struct {
+	mu      sync.Mutex
+	servers []*http.Server
+	hooks   []func(context.Context) error
+	signals []os.Signal
+	drain   time.Duration
+	closing bool
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// DrainTimeout sets the time active requests are given to finish when a shutdown signal is received,
+// see Signals. It defaults to 30 seconds. Connections still active after it are closed.
+func (c *Cobalt) DrainTimeout(d time.Duration) {
+	c.life.mu.Lock()
+	defer c.life.mu.Unlock()
+	c.life.drain = d
+}
+
+// Signals sets the signals which shut down the servers started by Serve, they default to SIGINT and
+// SIGTERM. Without signals the app is only shut down by calling Shutdown, e.g. by a lifecycle
+// manager which handles the signals itself.
+func (c *Cobalt) Signals(sigs ...os.Signal) {
+	c.life.mu.Lock()
+	defer c.life.mu.Unlock()
+	c.life.signals = sigs
+}
+
+// OnShutdown registers a hook called by Shutdown once the requests have drained, e.g. to close
+// database connections. The hooks are called in the reverse order of their registration, with the
+// context passed to Shutdown.
+func (c *Cobalt) OnShutdown(f func(ctx context.Context) error) {
+	c.life.mu.Lock()
+	defer c.life.mu.Unlock()
+	c.life.hooks = append(c.life.hooks, f)
+}
+
+// Serve serves the app by the server until the app is shut down, by a signal or by Shutdown. The
+// server configures the address, the timeouts and the header limits, if it has no handler the app
+// is used. Unlike Run, Serve returns the error of the server instead of exiting. After a shutdown
+// it waits for the requests to drain and returns the error of Shutdown, which is nil if they did.
+func (c *Cobalt) Serve(srv *http.Server) error {
+	return c.serveWith(serverRun{srv, srv.ListenAndServe})
+}
+
+// serverRun is a server with the func which makes it listen and serve, e.g. its ListenAndServe.
+type serverRun struct {
+	srv    *http.Server
+	listen func() error
+}
+
+// serveWith runs the servers until the app is shut down, see Serve. If one of them fails while others
+// are running, the app is shut down and the error of the failed one is returned.
+func (c *Cobalt) serveWith(runs ...serverRun) error {
+	c.life.mu.Lock()
+	if c.life.closing {
+		c.life.mu.Unlock()
+		return http.ErrServerClosed
+	}
+	for _, r := range runs {
+		if r.srv.Handler == nil {
+			r.srv.Handler = c
+		}
+		c.life.servers = append(c.life.servers, r.srv)
+	}
+	sigs, drain := c.life.signals, c.life.drain
+	c.life.mu.Unlock()
+
+	shutdown := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), drain)
+		defer cancel()
+		c.Shutdown(ctx)
+	}
+
+	if len(sigs) > 0 {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, sigs...)
+		defer signal.Stop(sig)
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case s := <-sig:
+				log.Printf("cobalt: %s received, shutting down", s)
+				shutdown()
+			case <-stop:
+			}
+		}()
+	}
+
+	errc := make(chan error, len(runs))
+	for _, r := range runs {
+		go func(r serverRun) {
+			err := r.listen()
+			if err != http.ErrServerClosed {
+				c.removeServer(r.srv)
+				if len(runs) > 1 {
+					go shutdown()
+				}
+			}
+			errc <- err
+		}(r)
+	}
+
+	var failed error
+	for range runs {
+		if err := <-errc; err != http.ErrServerClosed && failed == nil {
+			failed = err
+		}
+	}
+	if failed != nil && len(runs) == 1 {
+		return failed
+	}
+
+	<-c.life.done
+	if failed != nil {
+		return failed
+	}
+	return c.life.err
+}
+
+// removeServer removes a failed server, unless the app is shut down already.
+func (c *Cobalt) removeServer(srv *http.Server) {
+	c.life.mu.Lock()
+	defer c.life.mu.Unlock()
+	if c.life.closing {
+		return
+	}
+	servers := c.life.servers[:0:0]
+	for _, s := range c.life.servers {
+		if s != srv {
+			servers = append(servers, s)
+		}
+	}
+	c.life.servers = servers
+}
+
+// Shutdown gracefully shuts down the servers started by Serve: they stop accepting connections and
+// wait for the active requests to finish. If the context expires first, the remaining connections
+// are closed and the error of the context is returned. The shutdown hooks are called afterwards,
+// see OnShutdown. The app can't be served again once it is shut down, further calls return the
+// result of the first one.
+func (c *Cobalt) Shutdown(ctx context.Context) error {
+	c.life.once.Do(func() {
+		c.life.mu.Lock()
+		c.life.closing = true
+		servers, hooks := c.life.servers, c.life.hooks
+		c.life.mu.Unlock()
+
+		var wg sync.WaitGroup
+		errs := make([]error, len(servers))
+		for i, srv := range servers {
+			wg.Add(1)
+			go func(i int, srv *http.Server) {
+				defer wg.Done()
+				if errs[i] = srv.Shutdown(ctx); errs[i] != nil {
+					srv.Close()
+				}
+			}(i, srv)
+		}
+		wg.Wait()
+
+		for i := len(hooks) - 1; i >= 0; i-- {
+			errs = append(errs, hooks[i](ctx))
+		}
+		for _, err := range errs {
+			if err != nil {
+				c.life.err = err
+				break
+			}
+		}
+		close(c.life.done)
+	})
+	<-c.life.done
+	return c.life.err
+}
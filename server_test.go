@@ -0,0 +1,126 @@
+package cobalt
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address which is free to listen on.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startServer serves the app on a free address and waits until it accepts requests.
+func startServer(t *testing.T, c *Cobalt) (string, chan error) {
+	addr := freeAddr(t)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Serve(&http.Server{Addr: addr, ReadHeaderTimeout: time.Second})
+	}()
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return addr, errc
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server on %s didn't start", addr)
+	return "", nil
+}
+
+// TestShutdown tests draining requests and calling the hooks on shutdown.
+func TestShutdown(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.Signals()
+
+	started := make(chan struct{})
+	c.Get("/slow", func(ctx *Context) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		ctx.Serve("done")
+	})
+	var hooks []string
+	c.OnShutdown(func(context.Context) error { hooks = append(hooks, "db"); return nil })
+	c.OnShutdown(func(context.Context) error { hooks = append(hooks, "cache"); return nil })
+
+	addr, errc := startServer(t, c)
+
+	resc := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			t.Error(err)
+			resc <- 0
+			return
+		}
+		resp.Body.Close()
+		resc <- resp.StatusCode
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatalf("expected no error instead got %v", err)
+	}
+	if code := <-resc; code != http.StatusOK {
+		t.Errorf("expected the active request to finish with 200 instead got %d", code)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("expected Serve to return nil instead got %v", err)
+	}
+	if len(hooks) != 2 || hooks[0] != "cache" || hooks[1] != "db" {
+		t.Errorf("expected the hooks in reverse order instead got %v", hooks)
+	}
+
+	if err := c.Serve(&http.Server{Addr: freeAddr(t)}); err != http.ErrServerClosed {
+		t.Errorf("expected ErrServerClosed after shutdown instead got %v", err)
+	}
+}
+
+// TestShutdownTimeout tests closing connections which don't drain in time.
+func TestShutdownTimeout(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.Signals()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	c.Get("/stuck", func(ctx *Context) {
+		close(started)
+		<-release
+	})
+
+	addr, errc := startServer(t, c)
+	go func() {
+		if resp, err := http.Get("http://" + addr + "/stuck"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded instead got %v", err)
+	}
+	if err := <-errc; err != context.DeadlineExceeded {
+		t.Errorf("expected Serve to return the error of Shutdown instead got %v", err)
+	}
+}
+
+// TestServeError tests returning the error of the server.
+func TestServeError(t *testing.T) {
+	c := New(&JSONEncoder{})
+	c.Signals()
+	if err := c.Serve(&http.Server{Addr: "127.0.0.1:-1"}); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+}
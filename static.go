@@ -0,0 +1,194 @@
+package cobalt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// staticParam is the name of the catch-all parameter of the routes registered by Static.
+const staticParam = "filepath"
+
+// fingerprint matches the hex part of file names with a content hash, e.g. app.3f2a9c1b.js or
+// logo-3f2a9c1b.png, see fingerprinted.
+var fingerprint = regexp.MustCompile(`[.-]([0-9a-fA-F]{8,})\.[^/]+$`)
+
+// fingerprinted reports whether the file name has a content hash. Unlike dates or ids, e.g.
+// invoice-20240101.pdf, a hash of at least 8 hex digits has a letter among them.
+func fingerprinted(name string) bool {
+	m := fingerprint.FindStringSubmatch(name)
+	return m != nil && strings.ContainsAny(m[1], "abcdefABCDEF")
+}
+
+// encodings are the precompressed variants Static looks for, in order of preference.
+var encodings = []struct{ name, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// StaticOptions configures how Static serves files.
+type StaticOptions struct {
+	// Index is the file served for a directory, it defaults to index.html.
+	Index string
+	// SPA serves the index file of the root for unknown paths which don't look like a file, i.e. their
+	// last segment has no extension, so that a single page app can do its own routing.
+	SPA bool
+	// MaxAge is the time fingerprinted files are cached, it defaults to a year. Fingerprinted files
+	// have a content hash of at least 8 hex digits with a letter among them in their name, e.g.
+	// app.3f2a9c1b.js, so they never change. Other files must be revalidated by the client.
+	MaxAge time.Duration
+	// Fingerprinted overrides the detection of fingerprinted files by their name.
+	Fingerprinted func(name string) bool
+}
+
+// Static serves the files of fsys, e.g. an embed.FS or os.DirFS, for GET and HEAD requests below the
+// prefix, e.g. /assets/app.js is served from app.js for the prefix /assets. If the client accepts
+// them, the precompressed variants of a file are served instead, i.e. app.js.br or app.js.gz.
+// Requests go through the middleware, the logging and the not found handler like any other.
+// Directories are served by their index file, they are never listed. Requests for a directory without
+// a trailing slash are redirected to the path with it by a relative redirect, like http.FileServer does.
+func (c *Cobalt) Static(prefix string, fsys fs.FS, opts StaticOptions, m ...MiddleWare) error {
+	if !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("static prefix %q must begin with '/'", prefix)
+	}
+	prefix = strings.TrimRight(prefix, "/")
+	if opts.Index == "" {
+		opts.Index = "index.html"
+	}
+	if opts.MaxAge == 0 {
+		opts.MaxAge = 365 * 24 * time.Hour
+	}
+	if opts.Fingerprinted == nil {
+		opts.Fingerprinted = fingerprinted
+	}
+
+	h := func(ctx *Context) {
+		name := strings.TrimPrefix(path.Clean("/"+ctx.ParamValue(staticParam)), "/")
+		if name == "" {
+			name = "."
+		}
+		file := name
+		if info, err := fs.Stat(fsys, name); err == nil && info.IsDir() {
+			// relative links of the index file resolve against the directory only with the slash, the
+			// redirect is relative too as the app may be mounted below another prefix
+			if u := ctx.Request.URL; !strings.HasSuffix(u.Path, "/") {
+				location := path.Base(u.EscapedPath()) + "/"
+				if u.RawQuery != "" {
+					location += "?" + u.RawQuery
+				}
+				ctx.Response.Header().Set("Location", location)
+				ctx.ServeStatus(http.StatusMovedPermanently)
+				return
+			}
+			file = path.Join(name, opts.Index)
+		}
+
+		err := serveFile(ctx, fsys, file, opts)
+		if errors.Is(err, fs.ErrNotExist) && opts.SPA && path.Ext(name) == "" {
+			err = serveFile(ctx, fsys, opts.Index, opts)
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, fs.ErrNotExist):
+			c.notFound(ctx)
+		case errors.Is(err, fs.ErrPermission):
+			ctx.Error(http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		default:
+			log.Printf("cobalt: serving %s: %v", file, err)
+			ctx.Error(http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}
+
+	routes := []string{prefix + "/*" + staticParam}
+	if prefix != "" {
+		routes = append(routes, prefix)
+	}
+	return c.handleAll([]string{"GET", "HEAD"}, routes, h, m)
+}
+
+// serveFile serves the named file or its best precompressed variant accepted by the client.
+func serveFile(ctx *Context, fsys fs.FS, name string, opts StaticOptions) error {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fs.ErrNotExist
+	}
+
+	header := ctx.Response.Header()
+	serve, encoding := name, ""
+	for _, enc := range encodings {
+		variant, err := fs.Stat(fsys, name+enc.ext)
+		if err != nil || variant.IsDir() {
+			continue
+		}
+		// caches must keep the variants apart once there is one
+		header.Set("Vary", "Accept-Encoding")
+		if encoding == "" && acceptsEncoding(ctx.Request, enc.name) {
+			serve, encoding = name+enc.ext, enc.name
+		}
+	}
+
+	f, err := fsys.Open(serve)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(b)
+	}
+
+	if encoding != "" {
+		header.Set("Content-Encoding", encoding)
+		// set the type of the file as ServeContent would sniff the compressed content
+		if typ := mime.TypeByExtension(path.Ext(name)); typ != "" {
+			header.Set("Content-Type", typ)
+		} else {
+			header.Set("Content-Type", "application/octet-stream")
+		}
+	}
+	if opts.Fingerprinted(path.Base(name)) {
+		header.Set(cacheControlHeader, fmt.Sprintf("public, max-age=%d, immutable", int(opts.MaxAge.Seconds())))
+	} else {
+		header.Set(cacheControlHeader, "no-cache")
+	}
+
+	http.ServeContent(ctx.Response, ctx.Request, name, info.ModTime(), content)
+	return nil
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of the request accepts the encoding.
+func acceptsEncoding(req *http.Request, encoding string) bool {
+	for _, field := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(field, ";")
+		if strings.TrimSpace(parts[0]) != encoding {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package cobalt
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+var staticFiles = fstest.MapFS{
+	"index.html":              {Data: []byte("<html>app</html>")},
+	"app.3f2a9c1b.js":         {Data: []byte("console.log(1)")},
+	"app.3f2a9c1b.js.br":      {Data: []byte("br")},
+	"app.3f2a9c1b.js.gz":      {Data: []byte("gz")},
+	"css/site.css":            {Data: []byte("body{}")},
+	"docs/index.html":         {Data: []byte("<html>docs</html>")},
+	"docs/guide/readme.txt":   {Data: []byte("readme")},
+	"images/logo-0123456.png": {Data: []byte("png")},
+	"invoice-20240101.pdf":    {Data: []byte("pdf")},
+}
+
+// TestStatic tests serving files from a fs.FS.
+func TestStatic(t *testing.T) {
+	c := New(&JSONEncoder{})
+	if err := c.Static("/assets/", staticFiles, StaticOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, encoding string
+		code           int
+		body, typ      string // the body is the location of redirects
+		cache          string
+	}{
+		{"/assets", "", http.StatusMovedPermanently, "assets/", "", ""},
+		{"/assets/", "", http.StatusOK, "<html>app</html>", "text/html; charset=utf-8", "no-cache"},
+		{"/assets/docs", "", http.StatusMovedPermanently, "docs/", "", ""},
+		{"/assets/docs?v=1", "", http.StatusMovedPermanently, "docs/?v=1", "", ""},
+		{"/assets/docs/", "", http.StatusOK, "<html>docs</html>", "text/html; charset=utf-8", "no-cache"},
+		{"/assets/css/site.css", "", http.StatusOK, "body{}", "text/css; charset=utf-8", "no-cache"},
+		{"/assets/app.3f2a9c1b.js", "", http.StatusOK, "console.log(1)", "text/javascript; charset=utf-8", "public, max-age=31536000, immutable"},
+		{"/assets/app.3f2a9c1b.js", "gzip, deflate", http.StatusOK, "gz", "text/javascript; charset=utf-8", "public, max-age=31536000, immutable"},
+		{"/assets/app.3f2a9c1b.js", "gzip, br", http.StatusOK, "br", "text/javascript; charset=utf-8", "public, max-age=31536000, immutable"},
+		{"/assets/app.3f2a9c1b.js", "br;q=0, gzip", http.StatusOK, "gz", "text/javascript; charset=utf-8", "public, max-age=31536000, immutable"},
+		{"/assets/images/logo-0123456.png", "", http.StatusOK, "png", "image/png", "no-cache"},
+		{"/assets/invoice-20240101.pdf", "", http.StatusOK, "pdf", "application/pdf", "no-cache"},
+		{"/assets/../index.html", "", http.StatusOK, "<html>app</html>", "text/html; charset=utf-8", "no-cache"},
+		{"/assets/docs/guide/", "", http.StatusNotFound, "", "", ""},
+		{"/assets/missing.js", "", http.StatusNotFound, "", "", ""},
+		{"/assets/app/settings", "", http.StatusNotFound, "", "", ""},
+	}
+	for _, tt := range tests {
+		r := newRequest("GET", tt.path, nil)
+		r.Header.Set("Accept-Encoding", tt.encoding)
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+
+		if w.Code != tt.code {
+			t.Errorf("%s %q: expected status code %d instead got %d", tt.path, tt.encoding, tt.code, w.Code)
+			continue
+		}
+		if w.Header().Get("X-Request-Id") == "" {
+			t.Errorf("%s: expected request id", tt.path)
+		}
+		if tt.code == http.StatusMovedPermanently && w.Header().Get("Location") != tt.body {
+			t.Errorf("%s: expected redirect to %s instead got %q", tt.path, tt.body, w.Header().Get("Location"))
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		if w.Body.String() != tt.body || w.Header().Get("Content-Type") != tt.typ || w.Header().Get(cacheControlHeader) != tt.cache {
+			t.Errorf("%s %q: expected %q %s %s instead got %q %v", tt.path, tt.encoding, tt.body, tt.typ, tt.cache, w.Body.String(), w.Header())
+		}
+		if enc := w.Header().Get("Content-Encoding"); tt.body == "br" && enc != "br" || tt.body == "gz" && enc != "gzip" {
+			t.Errorf("%s %q: expected content encoding for %s instead got %q", tt.path, tt.encoding, tt.body, enc)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("HEAD", "/assets/css/site.css", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "6" {
+		t.Errorf("expected HEAD without body instead got %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	if err := c.Static("assets", staticFiles, StaticOptions{}); err == nil {
+		t.Error("expected error for prefix without leading slash")
+	}
+}
+
+// TestStaticSPA tests falling back to the index file for single page apps.
+func TestStaticSPA(t *testing.T) {
+	c := New(&JSONEncoder{})
+	if err := c.Static("/", staticFiles, StaticOptions{SPA: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]int{"/app/settings": http.StatusOK, "/docs/guide/": http.StatusOK, "/docs/guide": http.StatusMovedPermanently, "/missing.js": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, newRequest("GET", path, nil))
+		if w.Code != want {
+			t.Errorf("%s: expected status code %d instead got %d", path, want, w.Code)
+		}
+		if want == http.StatusOK && w.Body.String() != "<html>app</html>" {
+			t.Errorf("%s: expected the index file instead got %q", path, w.Body.String())
+		}
+	}
+}
+
+// TestStaticMounted tests redirecting directories of an app mounted below another prefix.
+func TestStaticMounted(t *testing.T) {
+	sub := New(&JSONEncoder{})
+	if err := sub.Static("/", staticFiles, StaticOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	c := New(&JSONEncoder{})
+	if err := c.Mount("/ui", sub); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/ui/docs", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "docs/" {
+		t.Errorf("expected redirect to docs/ instead got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/ui/docs/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "<html>docs</html>" {
+		t.Errorf("expected the index file of docs instead got %d %q", w.Code, w.Body.String())
+	}
+}
+
+// brokenFS is a file system which fails to open its files.
+type brokenFS struct {
+	fstest.MapFS
+}
+
+func (fsys brokenFS) Open(name string) (fs.File, error) {
+	if name == "." {
+		return fsys.MapFS.Open(name)
+	}
+	return nil, errors.New("disk failure")
+}
+
+// TestStaticError tests answering errors of the file system.
+func TestStaticError(t *testing.T) {
+	c := New(&JSONEncoder{})
+	if err := c.Static("/", brokenFS{staticFiles}, StaticOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, newRequest("GET", "/css/site.css", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status code 500 instead got %d", w.Code)
+	}
+}
@@ -0,0 +1,148 @@
+package cobalt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultReloadInterval is how often the certificate files are checked for changes.
+const defaultReloadInterval = 10 * time.Second
+
+// TLSConfig configures serving TLS, see ServeTLS.
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM files of the certificate and its key. They are reloaded when
+	// they change, e.g. when they are rotated by a certificate manager. Without them the certificates
+	// of Config are used.
+	CertFile string
+	KeyFile  string
+	// ReloadInterval is how often the files are checked for changes, it defaults to 10 seconds. They
+	// are checked on new connections, so an idle server doesn't touch them.
+	ReloadInterval time.Duration
+	// Config is the base configuration, e.g. with the certificates, cipher suites or minimum version.
+	// It isn't modified.
+	Config *tls.Config
+	// ClientCAFile is the PEM file of the CAs which sign client certificates. With it clients must
+	// present a certificate signed by one of them (mutual TLS), see Context.PeerCertificate.
+	ClientCAFile string
+	// OptionalClientCert only verifies the certificates of the clients which present one.
+	OptionalClientCert bool
+}
+
+// ServeTLS is like Serve, but serves TLS with the configuration. The configuration of the server's
+// TLSConfig field is replaced by it.
+func (c *Cobalt) ServeTLS(srv *http.Server, cfg TLSConfig) error {
+	config, err := cfg.build()
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = config
+	return c.serveWith(serverRun{srv, func() error {
+		return srv.ListenAndServeTLS("", "")
+	}})
+}
+
+// build returns the tls.Config of the configuration.
+func (cfg TLSConfig) build() (*tls.Config, error) {
+	config := &tls.Config{}
+	if cfg.Config != nil {
+		config = cfg.Config.Clone()
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.ReloadInterval == 0 {
+			cfg.ReloadInterval = defaultReloadInterval
+		}
+		r, err := newCertReloader(cfg.CertFile, cfg.KeyFile, cfg.ReloadInterval)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = nil
+		config.GetCertificate = r.getCertificate
+	} else if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, errors.New("tls: neither certificate files nor certificates configured")
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates found in %s", cfg.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if cfg.OptionalClientCert {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return config, nil
+}
+
+// certReloader serves a certificate loaded from files and reloads it when they change.
+type certReloader struct {
+	certFile, keyFile string
+	interval          time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // of the newer file when the certificate was loaded
+	checked time.Time
+}
+
+// newCertReloader loads the certificate of the files.
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	r.checked = time.Now()
+	return r, nil
+}
+
+// getCertificate implements tls.Config.GetCertificate. It keeps serving the loaded certificate if the
+// changed files can't be loaded, e.g. because only one of them is written yet.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := time.Now(); now.Sub(r.checked) >= r.interval {
+		r.checked = now
+		if err := r.reload(); err != nil {
+			log.Printf("cobalt: reloading certificate %s: %v", r.certFile, err)
+		}
+	}
+	return r.cert, nil
+}
+
+// reload loads the certificate if the files changed since it was loaded.
+func (r *certReloader) reload() error {
+	var modTime time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if r.cert != nil && modTime.Equal(r.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
@@ -0,0 +1,212 @@
+package cobalt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, signed by a test CA.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// issue creates a certificate for the name with the usage, or a self-signed CA if ca is nil.
+func issue(t *testing.T, ca *testCert, name string, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := tmpl, key
+	if ca == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		tmpl.ExtKeyUsage = nil
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write writes the certificate and its key as PEM files to the directory.
+func (c *testCert) write(t *testing.T, dir string) (certFile, keyFile string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// tlsCertificate returns the certificate for a tls.Config.
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// startTLSServer serves the app with TLS on a free address and waits until it accepts connections.
+func startTLSServer(t *testing.T, c *Cobalt, cfg TLSConfig) (string, chan error) {
+	addr := freeAddr(t)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.ServeTLS(&http.Server{Addr: addr, ErrorLog: log.New(io.Discard, "", 0)}, cfg)
+	}()
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return addr, errc
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server on %s didn't start", addr)
+	return "", nil
+}
+
+// tlsGet makes a request on a new connection and returns the response body and the common name of
+// the server certificate.
+func tlsGet(roots *x509.CertPool, client *testCert, url string) (string, string, error) {
+	config := &tls.Config{RootCAs: roots}
+	if client != nil {
+		// always present the certificate, even if the server doesn't accept its CA
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert := client.tlsCertificate()
+			return &cert, nil
+		}
+	}
+	hc := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+	resp, err := hc.Get(url)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), resp.TLS.PeerCertificates[0].Subject.CommonName, err
+}
+
+// TestServeTLS tests serving TLS with certificates reloaded from files.
+func TestServeTLS(t *testing.T) {
+	ca := issue(t, nil, "ca", x509.ExtKeyUsageServerAuth)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	dir := t.TempDir()
+	certFile, keyFile := issue(t, ca, "server-1", x509.ExtKeyUsageServerAuth).write(t, dir)
+
+	c := New(&JSONEncoder{})
+	c.Signals()
+	c.Get("/", func(ctx *Context) {
+		ctx.Response.Write([]byte(ctx.PeerIdentity()))
+	})
+	addr, errc := startTLSServer(t, c, TLSConfig{CertFile: certFile, KeyFile: keyFile, ReloadInterval: time.Millisecond})
+
+	if body, cn, err := tlsGet(roots, nil, "https://"+addr+"/"); err != nil || cn != "server-1" || body != "" {
+		t.Fatalf("expected server-1 without peer instead got %q %q %v", cn, body, err)
+	}
+
+	// rotate the certificate
+	issue(t, ca, "server-2", x509.ExtKeyUsageServerAuth).write(t, dir)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	time.Sleep(5 * time.Millisecond)
+	if _, cn, err := tlsGet(roots, nil, "https://"+addr+"/"); err != nil || cn != "server-2" {
+		t.Errorf("expected the reloaded certificate server-2 instead got %q %v", cn, err)
+	}
+
+	// a broken key keeps the loaded certificate
+	os.WriteFile(keyFile, []byte("broken"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+	time.Sleep(5 * time.Millisecond)
+	if _, cn, err := tlsGet(roots, nil, "https://"+addr+"/"); err != nil || cn != "server-2" {
+		t.Errorf("expected the loaded certificate server-2 instead got %q %v", cn, err)
+	}
+
+	c.Shutdown(context.Background())
+	if err := <-errc; err != nil {
+		t.Errorf("expected Serve to return nil instead got %v", err)
+	}
+
+	if err := New(&JSONEncoder{}).ServeTLS(&http.Server{}, TLSConfig{}); err == nil {
+		t.Error("expected an error without certificates")
+	}
+}
+
+// TestServeMutualTLS tests verifying client certificates.
+func TestServeMutualTLS(t *testing.T) {
+	ca := issue(t, nil, "ca", x509.ExtKeyUsageServerAuth)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.der}), 0600)
+	client := issue(t, ca, "client-1", x509.ExtKeyUsageClientAuth)
+	stranger := issue(t, issue(t, nil, "other ca", x509.ExtKeyUsageServerAuth), "stranger", x509.ExtKeyUsageClientAuth)
+
+	for _, optional := range []bool{false, true} {
+		c := New(&JSONEncoder{})
+		c.Signals()
+		c.Get("/", func(ctx *Context) {
+			ctx.Response.Write([]byte(ctx.PeerIdentity()))
+		})
+		cfg := TLSConfig{
+			Config:             &tls.Config{Certificates: []tls.Certificate{issue(t, ca, "server", x509.ExtKeyUsageServerAuth).tlsCertificate()}},
+			ClientCAFile:       caFile,
+			OptionalClientCert: optional,
+		}
+		addr, errc := startTLSServer(t, c, cfg)
+
+		if body, _, err := tlsGet(roots, client, "https://"+addr+"/"); err != nil || body != "client-1" {
+			t.Errorf("optional %v: expected the peer client-1 instead got %q %v", optional, body, err)
+		}
+		if _, _, err := tlsGet(roots, stranger, "https://"+addr+"/"); err == nil {
+			t.Errorf("optional %v: expected an error for an unknown client certificate", optional)
+		}
+		body, _, err := tlsGet(roots, nil, "https://"+addr+"/")
+		if optional && (err != nil || body != "") {
+			t.Errorf("expected no peer without client certificate instead got %q %v", body, err)
+		}
+		if !optional && err == nil {
+			t.Error("expected an error without client certificate")
+		}
+
+		c.Shutdown(context.Background())
+		<-errc
+	}
+}